@@ -4,9 +4,15 @@ go 1.16
 
 require (
 	github.com/grpc-ecosystem/go-grpc-middleware v1.2.2
+	github.com/jsternberg/zap-logfmt v1.2.0
 	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.7.0
 	go.opencensus.io v0.23.0
+	go.uber.org/multierr v1.5.0
 	go.uber.org/zap v1.16.0
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
 	google.golang.org/grpc v1.33.2
+	google.golang.org/protobuf v1.25.0
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
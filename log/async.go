@@ -0,0 +1,574 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// OverflowPolicy decides what Async does when its buffer is full
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // wait for the writer to free a slot
+	OverflowDropOldest                       // overwrite the oldest buffered entry
+	OverflowDropNewest                       // discard the entry being sent
+)
+
+const (
+	defaultAsyncBufferSize    = 1024
+	defaultAsyncFlushInterval = time.Second
+)
+
+// AsyncConfig configures the buffered write path
+type AsyncConfig struct {
+	BufferSize    int            // max buffered entries (default 1024)
+	Overflow      OverflowPolicy // what to do when the buffer is full
+	FlushInterval time.Duration  // how often the outputs are synced (default 1s)
+}
+
+// Async makes Send enqueue entries into a bounded buffer written by a background goroutine.
+// Fields are encoded before Send returns, so the values logged can be changed right after.
+// Sync/Close drain the buffer, dropped entries are counted for DroppedReport
+func Async(cfg AsyncConfig) Option {
+	return optionFn(func(input *StandardLogger) {
+		if cfg.BufferSize <= 0 {
+			cfg.BufferSize = defaultAsyncBufferSize
+		}
+		if cfg.FlushInterval <= 0 {
+			cfg.FlushInterval = defaultAsyncFlushInterval
+		}
+
+		var q *asyncQueue
+		input.logger = input.logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			q = newAsyncQueue(core, cfg, input.dropCounter())
+			return &asyncCore{Core: core, queue: q}
+		}))
+
+		input.closers = append(input.closers, closerFn(q.close))
+	})
+}
+
+type asyncItem struct {
+	checked *zapcore.CheckedEntry
+	fields  []zapcore.Field
+}
+
+// asyncQueue is a ring buffer drained by a single writer goroutine
+type asyncQueue struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	idle     *sync.Cond
+
+	items    []asyncItem
+	head     int
+	count    int
+	writing  bool
+	closed   bool
+	overflow OverflowPolicy
+
+	root    zapcore.Core
+	dropped *dropCounter
+	done    chan struct{}
+}
+
+func newAsyncQueue(root zapcore.Core, cfg AsyncConfig, dropped *dropCounter) *asyncQueue {
+	q := &asyncQueue{
+		items:    make([]asyncItem, cfg.BufferSize),
+		overflow: cfg.Overflow,
+		root:     root,
+		dropped:  dropped,
+		done:     make(chan struct{}),
+	}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	q.idle = sync.NewCond(&q.mu)
+
+	go q.run(cfg.FlushInterval)
+
+	return q
+}
+
+// push enqueues an item, returning false if the queue is closed and the caller must write it itself
+func (q *asyncQueue) push(item asyncItem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.count == len(q.items) && !q.closed {
+		switch q.overflow {
+		case OverflowDropNewest:
+			q.dropped.add(item.checked.Message)
+			return true
+		case OverflowDropOldest:
+			q.dropped.add(q.items[q.head].checked.Message)
+			q.items[q.head] = asyncItem{}
+			q.head = (q.head + 1) % len(q.items)
+			q.count--
+		default:
+			q.notFull.Wait()
+		}
+	}
+
+	if q.closed {
+		return false
+	}
+
+	q.items[(q.head+q.count)%len(q.items)] = item
+	q.count++
+	q.notEmpty.Signal()
+
+	return true
+}
+
+func (q *asyncQueue) run(flushInterval time.Duration) {
+	defer close(q.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	// wake the writer so it can sync even when no entries arrive
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				q.mu.Lock()
+				q.notEmpty.Signal()
+				q.mu.Unlock()
+			case <-q.done:
+				return
+			}
+		}
+	}()
+
+	lastSync := time.Now()
+	batch := make([]asyncItem, 0, len(q.items))
+
+	for {
+		q.mu.Lock()
+		for q.count == 0 && !q.closed && time.Since(lastSync) < flushInterval {
+			q.writing = false
+			q.idle.Broadcast()
+			q.notEmpty.Wait()
+		}
+
+		if q.count == 0 && q.closed {
+			q.writing = false
+			q.idle.Broadcast()
+			q.mu.Unlock()
+			return
+		}
+
+		batch = batch[:0]
+		for q.count > 0 {
+			batch = append(batch, q.items[q.head])
+			q.items[q.head] = asyncItem{}
+			q.head = (q.head + 1) % len(q.items)
+			q.count--
+		}
+		q.writing = true
+		q.notFull.Broadcast()
+		q.mu.Unlock()
+
+		for _, item := range batch {
+			item.checked.Write(item.fields...)
+		}
+
+		if time.Since(lastSync) >= flushInterval {
+			_ = q.root.Sync()
+			lastSync = time.Now()
+		}
+	}
+}
+
+// drain waits until every queued entry has been written
+func (q *asyncQueue) drain() {
+	q.mu.Lock()
+	for q.count > 0 || q.writing {
+		q.notEmpty.Signal()
+		q.idle.Wait()
+	}
+	q.mu.Unlock()
+}
+
+func (q *asyncQueue) close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.notEmpty.Signal()
+	q.notFull.Broadcast()
+	q.mu.Unlock()
+
+	<-q.done
+
+	return q.root.Sync()
+}
+
+// asyncCore runs the wrapped core's Check synchronously (levels, sampling, tees)
+// and defers only the writes
+type asyncCore struct {
+	zapcore.Core
+	queue *asyncQueue
+}
+
+func (c *asyncCore) With(fields []zapcore.Field) zapcore.Core {
+	return &asyncCore{Core: c.Core.With(fields), queue: c.queue}
+}
+
+func (c *asyncCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+
+	checked := c.Core.Check(ent, nil)
+	if checked == nil {
+		return ce
+	}
+
+	return ce.AddCore(ent, &asyncWriter{queue: c.queue, checked: checked})
+}
+
+func (c *asyncCore) Sync() error {
+	c.queue.drain()
+	return c.Core.Sync()
+}
+
+// asyncWriter enqueues a single checked entry
+type asyncWriter struct {
+	queue   *asyncQueue
+	checked *zapcore.CheckedEntry
+}
+
+func (w *asyncWriter) Enabled(zapcore.Level) bool { return true }
+
+func (w *asyncWriter) With([]zapcore.Field) zapcore.Core { return w }
+
+func (w *asyncWriter) Check(_ zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce
+}
+
+func (w *asyncWriter) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	// the logger adds caller & stack to the outer entry after Check
+	w.checked.Entry = ent
+	fields = encodeAsyncFields(fields)
+
+	if !w.queue.push(asyncItem{checked: w.checked, fields: fields}) {
+		// closed: write synchronously so late entries are not lost
+		w.checked.Write(fields...)
+	}
+
+	return nil
+}
+
+func (w *asyncWriter) Sync() error { return nil }
+
+// encodeAsyncFields encodes the values referenced by fields (maps, slices & marshalers of the caller)
+// into recorded encoder calls, replayed by the writer goroutine on the outputs' encoders
+func encodeAsyncFields(fields []zapcore.Field) []zapcore.Field {
+	encoded := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		encoded[i] = encodeAsyncField(f)
+	}
+	return encoded
+}
+
+func encodeAsyncField(f zapcore.Field) zapcore.Field {
+	switch f.Type {
+	case zapcore.ObjectMarshalerType:
+		obj := &encodedObject{}
+		obj.err = f.Interface.(zapcore.ObjectMarshaler).MarshalLogObject(obj)
+		return zap.Object(f.Key, obj)
+	case zapcore.ArrayMarshalerType:
+		arr := &encodedArray{}
+		arr.err = f.Interface.(zapcore.ArrayMarshaler).MarshalLogArray(arr)
+		return zap.Array(f.Key, arr)
+	case zapcore.ReflectType:
+		v, err := encodeReflected(f.Interface)
+		if err != nil {
+			return zap.String(f.Key+"Error", err.Error())
+		}
+		return zap.Reflect(f.Key, v)
+	case zapcore.BinaryType:
+		return zap.Binary(f.Key, append([]byte(nil), f.Interface.([]byte)...))
+	case zapcore.ByteStringType:
+		return zap.ByteString(f.Key, append([]byte(nil), f.Interface.([]byte)...))
+	}
+	return f
+}
+
+// encodeReflected returns immutable values as is & encodes the others as JSON
+func encodeReflected(v interface{}) (interface{}, error) {
+	if _, ok := v.(json.RawMessage); ok || v == nil {
+		return v, nil
+	}
+	if kind := reflect.ValueOf(v).Kind(); kind <= reflect.Complex128 || kind == reflect.String {
+		return v, nil
+	}
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
+
+// encodedObject records the calls of a MarshalLogObject
+type encodedObject struct {
+	ops []func(zapcore.ObjectEncoder)
+	err error
+}
+
+func (o *encodedObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, op := range o.ops {
+		op(enc)
+	}
+	return o.err
+}
+
+func (o *encodedObject) add(op func(zapcore.ObjectEncoder)) {
+	o.ops = append(o.ops, op)
+}
+
+func (o *encodedObject) AddArray(key string, v zapcore.ArrayMarshaler) error {
+	arr := &encodedArray{}
+	arr.err = v.MarshalLogArray(arr)
+	o.add(func(enc zapcore.ObjectEncoder) { _ = enc.AddArray(key, arr) })
+	return arr.err
+}
+
+func (o *encodedObject) AddObject(key string, v zapcore.ObjectMarshaler) error {
+	obj := &encodedObject{}
+	obj.err = v.MarshalLogObject(obj)
+	o.add(func(enc zapcore.ObjectEncoder) { _ = enc.AddObject(key, obj) })
+	return obj.err
+}
+
+func (o *encodedObject) AddReflected(key string, v interface{}) error {
+	v, err := encodeReflected(v)
+	if err != nil {
+		return err
+	}
+	o.add(func(enc zapcore.ObjectEncoder) { _ = enc.AddReflected(key, v) })
+	return nil
+}
+
+func (o *encodedObject) AddBinary(key string, v []byte) {
+	v = append([]byte(nil), v...)
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddBinary(key, v) })
+}
+
+func (o *encodedObject) AddByteString(key string, v []byte) {
+	v = append([]byte(nil), v...)
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddByteString(key, v) })
+}
+
+func (o *encodedObject) OpenNamespace(key string) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.OpenNamespace(key) })
+}
+
+func (o *encodedObject) AddBool(key string, v bool) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddBool(key, v) })
+}
+
+func (o *encodedObject) AddComplex128(key string, v complex128) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddComplex128(key, v) })
+}
+
+func (o *encodedObject) AddComplex64(key string, v complex64) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddComplex64(key, v) })
+}
+
+func (o *encodedObject) AddDuration(key string, v time.Duration) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddDuration(key, v) })
+}
+
+func (o *encodedObject) AddFloat64(key string, v float64) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddFloat64(key, v) })
+}
+
+func (o *encodedObject) AddFloat32(key string, v float32) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddFloat32(key, v) })
+}
+
+func (o *encodedObject) AddInt(key string, v int) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddInt(key, v) })
+}
+
+func (o *encodedObject) AddInt64(key string, v int64) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddInt64(key, v) })
+}
+
+func (o *encodedObject) AddInt32(key string, v int32) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddInt32(key, v) })
+}
+
+func (o *encodedObject) AddInt16(key string, v int16) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddInt16(key, v) })
+}
+
+func (o *encodedObject) AddInt8(key string, v int8) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddInt8(key, v) })
+}
+
+func (o *encodedObject) AddString(key, v string) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddString(key, v) })
+}
+
+func (o *encodedObject) AddTime(key string, v time.Time) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddTime(key, v) })
+}
+
+func (o *encodedObject) AddUint(key string, v uint) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddUint(key, v) })
+}
+
+func (o *encodedObject) AddUint64(key string, v uint64) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddUint64(key, v) })
+}
+
+func (o *encodedObject) AddUint32(key string, v uint32) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddUint32(key, v) })
+}
+
+func (o *encodedObject) AddUint16(key string, v uint16) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddUint16(key, v) })
+}
+
+func (o *encodedObject) AddUint8(key string, v uint8) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddUint8(key, v) })
+}
+
+func (o *encodedObject) AddUintptr(key string, v uintptr) {
+	o.add(func(enc zapcore.ObjectEncoder) { enc.AddUintptr(key, v) })
+}
+
+// encodedArray records the calls of a MarshalLogArray
+type encodedArray struct {
+	ops []func(zapcore.ArrayEncoder)
+	err error
+}
+
+func (a *encodedArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, op := range a.ops {
+		op(enc)
+	}
+	return a.err
+}
+
+func (a *encodedArray) add(op func(zapcore.ArrayEncoder)) {
+	a.ops = append(a.ops, op)
+}
+
+func (a *encodedArray) AppendArray(v zapcore.ArrayMarshaler) error {
+	arr := &encodedArray{}
+	arr.err = v.MarshalLogArray(arr)
+	a.add(func(enc zapcore.ArrayEncoder) { _ = enc.AppendArray(arr) })
+	return arr.err
+}
+
+func (a *encodedArray) AppendObject(v zapcore.ObjectMarshaler) error {
+	obj := &encodedObject{}
+	obj.err = v.MarshalLogObject(obj)
+	a.add(func(enc zapcore.ArrayEncoder) { _ = enc.AppendObject(obj) })
+	return obj.err
+}
+
+func (a *encodedArray) AppendReflected(v interface{}) error {
+	v, err := encodeReflected(v)
+	if err != nil {
+		return err
+	}
+	a.add(func(enc zapcore.ArrayEncoder) { _ = enc.AppendReflected(v) })
+	return nil
+}
+
+func (a *encodedArray) AppendByteString(v []byte) {
+	v = append([]byte(nil), v...)
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendByteString(v) })
+}
+
+func (a *encodedArray) AppendBool(v bool) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendBool(v) })
+}
+
+func (a *encodedArray) AppendComplex128(v complex128) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendComplex128(v) })
+}
+
+func (a *encodedArray) AppendComplex64(v complex64) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendComplex64(v) })
+}
+
+func (a *encodedArray) AppendDuration(v time.Duration) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendDuration(v) })
+}
+
+func (a *encodedArray) AppendFloat64(v float64) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendFloat64(v) })
+}
+
+func (a *encodedArray) AppendFloat32(v float32) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendFloat32(v) })
+}
+
+func (a *encodedArray) AppendInt(v int) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendInt(v) })
+}
+
+func (a *encodedArray) AppendInt64(v int64) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendInt64(v) })
+}
+
+func (a *encodedArray) AppendInt32(v int32) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendInt32(v) })
+}
+
+func (a *encodedArray) AppendInt16(v int16) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendInt16(v) })
+}
+
+func (a *encodedArray) AppendInt8(v int8) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendInt8(v) })
+}
+
+func (a *encodedArray) AppendString(v string) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendString(v) })
+}
+
+func (a *encodedArray) AppendTime(v time.Time) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendTime(v) })
+}
+
+func (a *encodedArray) AppendUint(v uint) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendUint(v) })
+}
+
+func (a *encodedArray) AppendUint64(v uint64) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendUint64(v) })
+}
+
+func (a *encodedArray) AppendUint32(v uint32) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendUint32(v) })
+}
+
+func (a *encodedArray) AppendUint16(v uint16) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendUint16(v) })
+}
+
+func (a *encodedArray) AppendUint8(v uint8) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendUint8(v) })
+}
+
+func (a *encodedArray) AppendUintptr(v uintptr) {
+	a.add(func(enc zapcore.ArrayEncoder) { enc.AppendUintptr(v) })
+}
@@ -0,0 +1,83 @@
+package log
+
+import (
+	"context"
+
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+)
+
+type ctxFieldsKey struct{}
+
+type ctxSpanContextKey struct{}
+
+type ctxLoggerKey struct{}
+
+// noopLogger is returned by FromContext when no Logger is attached
+var noopLogger = &StandardLogger{
+	namespace: "noop",
+	isNoop:    true,
+	logger:    zap.NewNop(),
+}
+
+// ContextWithFields returns a copy of ctx carrying the given fields,
+// which are added to every LogEntry attached to it via For
+func ContextWithFields(ctx context.Context, fields ...Field) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+
+	existing := FieldsFromContext(ctx)
+	merged := make([]Field, 0, len(existing)+len(fields))
+	merged = append(merged, existing...)
+	merged = append(merged, fields...)
+
+	return context.WithValue(ctx, ctxFieldsKey{}, merged)
+}
+
+// FieldsFromContext returns the fields attached to ctx with ContextWithFields
+func FieldsFromContext(ctx context.Context) []Field {
+	if ctx == nil {
+		return nil
+	}
+
+	fields, _ := ctx.Value(ctxFieldsKey{}).([]Field)
+	return fields
+}
+
+// ContextWithLogger returns a copy of ctx carrying the Logger
+func ContextWithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, ctxLoggerKey{}, logger)
+}
+
+// FromContext returns the Logger attached to ctx, or a noop Logger if there is none
+func FromContext(ctx context.Context) Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(ctxLoggerKey{}).(Logger); ok && logger != nil {
+			return logger
+		}
+	}
+
+	return noopLogger
+}
+
+// ContextWithSpanContext returns a copy of ctx carrying a span context without a span
+// (e.g. the caller's, from a W3C traceparent header), used by For when ctx has no span
+func ContextWithSpanContext(ctx context.Context, sc trace.SpanContext) context.Context {
+	return context.WithValue(ctx, ctxSpanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the context of the span in ctx,
+// or the one attached with ContextWithSpanContext
+func SpanContextFromContext(ctx context.Context) (trace.SpanContext, bool) {
+	if ctx == nil {
+		return trace.SpanContext{}, false
+	}
+
+	if span := trace.FromContext(ctx); span != nil {
+		return span.SpanContext(), true
+	}
+
+	sc, ok := ctx.Value(ctxSpanContextKey{}).(trace.SpanContext)
+	return sc, ok
+}
@@ -0,0 +1,89 @@
+package log
+
+import (
+	"os"
+
+	zaplogfmt "github.com/jsternberg/zap-logfmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewJSONEncoder returns the production JSON encoder
+func NewJSONEncoder() zapcore.Encoder {
+	return zapcore.NewJSONEncoder(defaultEncoderConfig())
+}
+
+// NewConsoleEncoder returns a human-readable, tab-separated encoder (optionally with colored levels)
+func NewConsoleEncoder(color bool) zapcore.Encoder {
+	return zapcore.NewConsoleEncoder(consoleEncoderConfig(color))
+}
+
+// NewLogfmtEncoder returns an encoder writing key=value pairs
+func NewLogfmtEncoder() zapcore.Encoder {
+	cfg := defaultEncoderConfig()
+	cfg.EncodeDuration = zapcore.StringDurationEncoder
+	return zaplogfmt.NewEncoder(cfg)
+}
+
+// ConsoleEncoder switches the logger output to stdout with the console encoder,
+// keeping the current minimum level
+func ConsoleEncoder(color bool) Option {
+	return withStdoutEncoder(NewConsoleEncoder(color))
+}
+
+// LogfmtEncoder switches the logger output to stdout with the logfmt encoder,
+// keeping the current minimum level
+func LogfmtEncoder() Option {
+	return withStdoutEncoder(NewLogfmtEncoder())
+}
+
+func withStdoutEncoder(enc zapcore.Encoder) Option {
+	return optionFn(func(input *StandardLogger) {
+		input.logger = input.logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewCore(enc, zapcore.Lock(os.Stdout), minEnabledLevel(core)).
+				With([]zap.Field{zap.String("namespace", input.namespace)})
+		}))
+	})
+}
+
+// NewDevelopment creates a logger for local development: colored console output,
+// Debug level, caller info & stack traces from Warning up
+func NewDevelopment(namespace string, options ...Option) (*StandardLogger, error) {
+	cfg := DevelopmentConfig(namespace)
+	return New(namespace, cfg, options...)
+}
+
+func DevelopmentConfig(namespace string) *zap.Logger {
+	conf := zap.NewDevelopmentConfig()
+	conf.EncoderConfig = consoleEncoderConfig(true)
+	conf.OutputPaths = []string{"stdout"}
+	conf.ErrorOutputPaths = []string{"stderr"}
+	zapLogger, _ := conf.Build(
+		zap.Fields(zap.String("namespace", namespace)),
+		zap.AddStacktrace(zapcore.WarnLevel),
+		zap.AddCallerSkip(1),
+	)
+
+	return zapLogger
+}
+
+func consoleEncoderConfig(color bool) zapcore.EncoderConfig {
+	cfg := zap.NewDevelopmentEncoderConfig()
+	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	cfg.EncodeDuration = zapcore.StringDurationEncoder
+	cfg.FunctionKey = "func"
+	if color {
+		cfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	return cfg
+}
+
+// minEnabledLevel returns the lowest level the core writes
+func minEnabledLevel(core zapcore.Core) zapcore.Level {
+	for lvl := zapcore.DebugLevel; lvl < zapcore.FatalLevel; lvl++ {
+		if core.Enabled(lvl) {
+			return lvl
+		}
+	}
+	return zapcore.FatalLevel
+}
@@ -0,0 +1,89 @@
+package log
+
+import (
+	stderrors "errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const maxCapturedFrames = 32
+
+type causer interface {
+	Cause() error
+}
+
+type stackTracer interface {
+	StackTrace() errors.StackTrace
+}
+
+// errorCauses returns the messages of err and every error it wraps, outermost first
+// (pkg/errors wraps twice per Wrap call, so repeated messages are skipped)
+func errorCauses(err error) []string {
+	var causes []string
+	for err != nil {
+		msg := err.Error()
+		if len(causes) == 0 || causes[len(causes)-1] != msg {
+			causes = append(causes, msg)
+		}
+		err = unwrapError(err)
+	}
+	return causes
+}
+
+// rootError returns the innermost error in the chain
+func rootError(err error) error {
+	for {
+		next := unwrapError(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
+
+// errorStack returns the deepest pkg/errors stack trace in the chain,
+// or the stack of the caller if none of the errors carry one
+func errorStack(err error, skip int) string {
+	var st stackTracer
+	for e := err; e != nil; e = unwrapError(e) {
+		if s, ok := e.(stackTracer); ok {
+			st = s
+		}
+	}
+
+	if st != nil {
+		return strings.TrimPrefix(fmt.Sprintf("%+v", st.StackTrace()), "\n")
+	}
+
+	return captureStack(skip + 1)
+}
+
+func unwrapError(err error) error {
+	if c, ok := err.(causer); ok {
+		return c.Cause()
+	}
+	return stderrors.Unwrap(err)
+}
+
+func captureStack(skip int) string {
+	pcs := make([]uintptr, maxCapturedFrames)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var sb strings.Builder
+	for {
+		frame, more := frames.Next()
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%s\n\t%s:%d", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	return sb.String()
+}
@@ -7,15 +7,50 @@ type Logger interface {
 	Info(string) Entry    // Init new LogEntry with level Info
 	Warning(string) Entry // Init new LogEntry with level Warning
 	Error(string) Entry   // Init new LogEntry with level Error
+
+	// Create a child Logger which adds the given fields to every LogEntry
+	With(fields ...Field) Logger
+
+	// Create a child Logger with the given name appended to the logger name
+	Named(name string) Logger
 }
 
 type Entry interface {
-	// Attach LogEntry to Context (for TraceID propagation)
+	// Attach LogEntry to Context (for TraceID propagation & context fields)
 	For(context.Context) Entry
 
 	// Add custom data to LogEntry
 	WithField(key string, value interface{}) Entry
 
+	// Add error message, type, cause chain & stack trace to LogEntry
+	// (also marks the attached trace span as failed)
+	WithError(error) Entry
+
 	// Send LogEntry to stdout
 	Send()
 }
+
+// CallerSkipper is implemented by the entries which can report the caller skip frames above
+// the function calling Send (for helpers & adapters wrapping the logger)
+type CallerSkipper interface {
+	AddCallerSkip(skip int) Entry
+}
+
+// AddCallerSkip skips frames on entries implementing CallerSkipper, others are returned as is
+func AddCallerSkip(e Entry, skip int) Entry {
+	if s, ok := e.(CallerSkipper); ok {
+		return s.AddCallerSkip(skip)
+	}
+	return e
+}
+
+// Field is a key/value pair attached to a child Logger
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F is a shorthand constructor for Field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
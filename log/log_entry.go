@@ -3,9 +3,17 @@ package log
 import (
 	"context"
 	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.opencensus.io/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/proto"
 )
 
 // logEntry is the default implementation of the Entry interface
@@ -15,22 +23,64 @@ type logEntry struct {
 	message     string
 	isNoop      bool
 	span        *trace.Span
+	err         error
 	logFields   []zap.Field
 	traceFields []trace.Attribute
 	logger      *zap.Logger
+	redactor    *redactor
+	correlation *traceCorrelation
+	callerSkip  int
+	callerPC    uintptr   // set by adapters which already know the call site
+	time        time.Time // set by adapters which already know the entry time
 }
 
 func (l *logEntry) For(ctx context.Context) Entry {
-	if span := trace.FromContext(ctx); span != nil {
-		l.span = span
-		l.WithField("TraceID", l.span.SpanContext().TraceID.String())
-		l.WithField("SpanID", l.span.SpanContext().SpanID.String())
+	if ctx == nil {
+		return l
 	}
+
+	// only OpenCensus spans are annotated, other span contexts are just correlated
+	l.span = trace.FromContext(ctx)
+	if sc, ok := l.correlation.spanContext(ctx); ok {
+		for _, f := range l.correlation.fields(sc) {
+			l.WithField(f.Key, f.Value)
+		}
+	}
+
+	for _, f := range FieldsFromContext(ctx) {
+		l.WithField(f.Key, f.Value)
+	}
+
 	return l
 }
 
 func (l *logEntry) WithField(key string, value interface{}) Entry {
-	logField, traceField := l.convertField(key, value)
+	logField, traceField := convertField(key, value)
+	return l.addField(logField, traceField)
+}
+
+func (l *logEntry) WithError(err error) Entry {
+	if err == nil {
+		return l
+	}
+
+	l.err = err
+	causes := errorCauses(err)
+	stack := errorStack(err, 1)
+	errType := fmt.Sprintf("%T", rootError(err))
+
+	l.addField(zap.String("error", err.Error()), trace.StringAttribute("error", err.Error()))
+	l.addField(zap.String("errorType", errType), trace.StringAttribute("errorType", errType))
+	if len(causes) > 1 {
+		l.addField(zap.Strings("errorCauses", causes[1:]), trace.StringAttribute("errorCauses", strings.Join(causes[1:], "\n")))
+	}
+	l.addField(zap.String("errorStack", stack), trace.StringAttribute("errorStack", stack))
+
+	return l
+}
+
+func (l *logEntry) addField(logField zap.Field, traceField trace.Attribute) Entry {
+	logField, traceField = l.redactor.apply(logField, traceField)
 
 	if l.logFields == nil {
 		l.logFields = []zap.Field{logField}
@@ -47,13 +97,28 @@ func (l *logEntry) WithField(key string, value interface{}) Entry {
 	return l
 }
 
-func (l logEntry) Send() {
+func (l *logEntry) AddCallerSkip(skip int) Entry {
+	l.callerSkip += skip
+	return l
+}
+
+func (l *logEntry) Send() {
 	if l.isNoop {
 		return
 	}
 
 	if l.span != nil {
-		l.span.Annotate(l.traceFields, l.message)
+		// the entry's fields follow the logger's in traceFields
+		offset := len(l.traceFields) - len(l.logFields)
+		for i, f := range l.logFields {
+			l.traceFields[offset+i] = traceAttribute(f, l.traceFields[offset+i])
+		}
+		annotateSpan(l.span, l.message, l.traceFields, l.err)
+	}
+
+	if l.callerSkip > 0 || l.callerPC != 0 || !l.time.IsZero() {
+		l.sendChecked()
+		return
 	}
 
 	switch l.level {
@@ -68,8 +133,71 @@ func (l logEntry) Send() {
 	}
 }
 
-func (l logEntry) convertField(key string, value interface{}) (logField zap.Field, traceField trace.Attribute) {
+// sendChecked replaces the caller zap reports (the caller of Send) with the one set
+// by AddCallerSkip or an adapter, & the entry time with the adapter's
+func (l *logEntry) sendChecked() {
+	ce := l.logger.Check(zapLevel(l.level), l.message)
+	if ce == nil {
+		return
+	}
+
+	if !l.time.IsZero() {
+		ce.Entry.Time = l.time
+	}
+
+	// only when caller reporting is enabled on the zap logger
+	if ce.Entry.Caller.Defined {
+		pc := l.callerPC
+		if pc == 0 {
+			// skip runtime.Callers, sendChecked & Send
+			var pcs [1]uintptr
+			if runtime.Callers(3+l.callerSkip, pcs[:]) > 0 {
+				pc = pcs[0]
+			}
+		}
+		ce.Entry.Caller = entryCaller(pc)
+	}
+
+	ce.Write(l.logFields...)
+}
+
+// annotateSpan adds the entry to the span, an error sets the span status
+func annotateSpan(span *trace.Span, message string, attributes []trace.Attribute, err error) {
+	span.Annotate(attributes, message)
+	if err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+}
+
+// entryCaller resolves a program counter as returned by runtime.Callers
+func entryCaller(pc uintptr) zapcore.EntryCaller {
+	if pc == 0 {
+		return zapcore.EntryCaller{}
+	}
+
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+
+	return zapcore.EntryCaller{
+		Defined:  frame.PC != 0,
+		PC:       frame.PC,
+		File:     frame.File,
+		Line:     frame.Line,
+		Function: frame.Function,
+	}
+}
+
+// convertField converts a field for zap & the trace span. Composite values get a zero trace attribute,
+// their JSON is only built by traceAttribute when a span is annotated
+func convertField(key string, value interface{}) (logField zap.Field, traceField trace.Attribute) {
+	// typed nil pointers are null, their Error, String & Marshal methods may panic
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return convertField(key, nil)
+	}
+
 	switch value.(type) {
+	case nil:
+		traceField = trace.StringAttribute(key, "null")
+		logField = zap.Reflect(key, nil)
 	case []byte:
 		v := string(value.([]byte))
 		traceField = trace.StringAttribute(key, v)
@@ -87,38 +215,104 @@ func (l logEntry) convertField(key string, value interface{}) (logField zap.Fiel
 		traceField = trace.BoolAttribute(key, v)
 		logField = zap.Bool(key, v)
 	case int:
-		v := int64(value.(int))
-		traceField = trace.Int64Attribute(key, v)
-		logField = zap.Int64(key, v)
+		v := value.(int)
+		traceField = trace.Int64Attribute(key, int64(v))
+		logField = zap.Int(key, v)
+	case int8:
+		v := value.(int8)
+		traceField = trace.Int64Attribute(key, int64(v))
+		logField = zap.Int8(key, v)
+	case int16:
+		v := value.(int16)
+		traceField = trace.Int64Attribute(key, int64(v))
+		logField = zap.Int16(key, v)
 	case int32:
-		v := int64(value.(int32))
-		traceField = trace.Int64Attribute(key, v)
-		logField = zap.Int64(key, v)
+		v := value.(int32)
+		traceField = trace.Int64Attribute(key, int64(v))
+		logField = zap.Int32(key, v)
 	case int64:
 		v := value.(int64)
 		traceField = trace.Int64Attribute(key, v)
 		logField = zap.Int64(key, v)
 	case uint:
-		v := int64(value.(uint))
-		traceField = trace.Int64Attribute(key, v)
-		logField = zap.Int64(key, v)
+		v := value.(uint)
+		traceField = uint64Attribute(key, uint64(v))
+		logField = zap.Uint(key, v)
+	case uint8:
+		v := value.(uint8)
+		traceField = trace.Int64Attribute(key, int64(v))
+		logField = zap.Uint8(key, v)
+	case uint16:
+		v := value.(uint16)
+		traceField = trace.Int64Attribute(key, int64(v))
+		logField = zap.Uint16(key, v)
 	case uint32:
-		v := int64(value.(uint32))
-		traceField = trace.Int64Attribute(key, v)
-		logField = zap.Int64(key, v)
+		v := value.(uint32)
+		traceField = trace.Int64Attribute(key, int64(v))
+		logField = zap.Uint32(key, v)
 	case uint64:
-		v := int64(value.(uint64))
-		traceField = trace.Int64Attribute(key, v)
-		logField = zap.Int64(key, v)
+		v := value.(uint64)
+		traceField = uint64Attribute(key, v)
+		logField = zap.Uint64(key, v)
+	case uintptr:
+		v := value.(uintptr)
+		traceField = uint64Attribute(key, uint64(v))
+		logField = zap.Uintptr(key, v)
 	case float32:
-		v := float64(value.(float32))
-		traceField = trace.Float64Attribute(key, v)
-		logField = zap.Float64(key, v)
+		v := value.(float32)
+		traceField = trace.Float64Attribute(key, float32To64(v))
+		logField = zap.Float32(key, v)
 	case float64:
 		v := value.(float64)
 		traceField = trace.Float64Attribute(key, v)
 		logField = zap.Float64(key, v)
+	case complex64:
+		v := value.(complex64)
+		traceField = trace.StringAttribute(key, strconv.FormatComplex(complex128(v), 'g', -1, 64))
+		logField = zap.Complex64(key, v)
+	case complex128:
+		v := value.(complex128)
+		traceField = trace.StringAttribute(key, strconv.FormatComplex(v, 'g', -1, 128))
+		logField = zap.Complex128(key, v)
+	case zapcore.ObjectMarshaler:
+		logField = zap.Object(key, value.(zapcore.ObjectMarshaler))
+	case zapcore.ArrayMarshaler:
+		logField = zap.Array(key, value.(zapcore.ArrayMarshaler))
+	case time.Time:
+		v := value.(time.Time)
+		traceField = trace.StringAttribute(key, v.Format(time.RFC3339Nano))
+		logField = zap.Time(key, v)
+	case time.Duration:
+		v := value.(time.Duration)
+		traceField = trace.StringAttribute(key, v.String())
+		logField = zap.Duration(key, v)
+	case proto.Message:
+		v := protoJSON(value.(proto.Message))
+		traceField = trace.StringAttribute(key, string(v))
+		logField = zap.Reflect(key, v)
+	case error:
+		v := value.(error).Error()
+		traceField = trace.StringAttribute(key, v)
+		logField = zap.String(key, v)
+	case fmt.Stringer:
+		v := value.(fmt.Stringer).String()
+		traceField = trace.StringAttribute(key, v)
+		logField = zap.String(key, v)
 	default:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() == reflect.Ptr {
+			return convertField(key, rv.Elem().Interface())
+		}
+
+		if base, ok := basicValue(rv); ok {
+			return convertField(key, base)
+		}
+
+		if f, ok := reflectField(key, value, 0); ok {
+			logField = f
+			break
+		}
+
 		v := fmt.Sprint(value)
 		traceField = trace.StringAttribute(key, v)
 		logField = zap.String(key, v)
@@ -126,3 +320,61 @@ func (l logEntry) convertField(key string, value interface{}) (logField zap.Fiel
 
 	return
 }
+
+// uint64Attribute keeps values above MaxInt64 as strings rather than wrapping them negative
+func uint64Attribute(key string, v uint64) trace.Attribute {
+	if v > math.MaxInt64 {
+		return trace.StringAttribute(key, strconv.FormatUint(v, 10))
+	}
+	return trace.Int64Attribute(key, int64(v))
+}
+
+// float32To64 widens v using its shortest decimal form, so 0.1 stays 0.1 rather than 0.10000000149011612
+func float32To64(v float32) float64 {
+	f, err := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+	if err != nil {
+		return float64(v) // NaN & Inf
+	}
+	return f
+}
+
+// basicValue converts named types (eg. type Level int) to their underlying basic type
+func basicValue(v reflect.Value) (interface{}, bool) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), true
+	case reflect.String:
+		return v.String(), true
+	case reflect.Int:
+		return int(v.Int()), true
+	case reflect.Int8:
+		return int8(v.Int()), true
+	case reflect.Int16:
+		return int16(v.Int()), true
+	case reflect.Int32:
+		return int32(v.Int()), true
+	case reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint:
+		return uint(v.Uint()), true
+	case reflect.Uint8:
+		return uint8(v.Uint()), true
+	case reflect.Uint16:
+		return uint16(v.Uint()), true
+	case reflect.Uint32:
+		return uint32(v.Uint()), true
+	case reflect.Uint64:
+		return v.Uint(), true
+	case reflect.Uintptr:
+		return uintptr(v.Uint()), true
+	case reflect.Float32:
+		return float32(v.Float()), true
+	case reflect.Float64:
+		return v.Float(), true
+	case reflect.Complex64:
+		return complex64(v.Complex()), true
+	case reflect.Complex128:
+		return v.Complex(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,308 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap/zapcore"
+)
+
+// encodeField returns the value stored for a converted field in zap & on the trace span
+func encodeField(value interface{}) (interface{}, interface{}) {
+	logField, traceField := convertField("key", value)
+
+	encoder := zapcore.NewMapObjectEncoder()
+	logField.AddTo(encoder)
+
+	traceField = traceAttribute(logField, traceField)
+	return encoder.Fields["key"], traceField.Value()
+}
+
+func TestConvertFieldSigned(t *testing.T) {
+	test := assert.New(t)
+
+	checks := map[string]interface{}{
+		"int": func(v int) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == int64(v) && traceValue == int64(v)
+		},
+		"int8": func(v int8) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == v && traceValue == int64(v)
+		},
+		"int16": func(v int16) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == v && traceValue == int64(v)
+		},
+		"int32": func(v int32) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == v && traceValue == int64(v)
+		},
+		"int64": func(v int64) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == v && traceValue == v
+		},
+	}
+
+	for name, fn := range checks {
+		test.NoError(quick.Check(fn, nil), name)
+	}
+
+	for _, v := range []int64{math.MinInt64, -1, 0, 1, math.MaxInt64} {
+		logValue, traceValue := encodeField(v)
+		test.Equal(v, logValue)
+		test.Equal(v, traceValue)
+	}
+}
+
+func TestConvertFieldUnsigned(t *testing.T) {
+	test := assert.New(t)
+
+	// values above MaxInt64 can't be an int64 trace attribute, so they become strings
+	traceUint := func(v uint64) interface{} {
+		if v > math.MaxInt64 {
+			return strconv.FormatUint(v, 10)
+		}
+		return int64(v)
+	}
+
+	checks := map[string]interface{}{
+		"uint": func(v uint) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == uint64(v) && traceValue == traceUint(uint64(v))
+		},
+		"uint8": func(v uint8) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == v && traceValue == int64(v)
+		},
+		"uint16": func(v uint16) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == v && traceValue == int64(v)
+		},
+		"uint32": func(v uint32) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == v && traceValue == int64(v)
+		},
+		"uint64": func(v uint64) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == v && traceValue == traceUint(v)
+		},
+		"uintptr": func(v uint64) bool {
+			logValue, traceValue := encodeField(uintptr(v))
+			return logValue == uintptr(v) && traceValue == traceUint(uint64(uintptr(v)))
+		},
+	}
+
+	for name, fn := range checks {
+		test.NoError(quick.Check(fn, nil), name)
+	}
+
+	for _, v := range []uint64{0, 1, math.MaxInt64, math.MaxInt64 + 1, math.MaxUint64} {
+		logValue, traceValue := encodeField(v)
+		test.Equal(v, logValue)
+		test.Equal(traceUint(v), traceValue)
+	}
+
+	_, traceValue := encodeField(uint64(math.MaxUint64))
+	test.Equal("18446744073709551615", traceValue)
+}
+
+func TestConvertFieldFloat(t *testing.T) {
+	test := assert.New(t)
+
+	checks := map[string]interface{}{
+		"float32": func(v float32) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == v && float32(traceValue.(float64)) == v
+		},
+		"float64": func(v float64) bool {
+			logValue, traceValue := encodeField(v)
+			return logValue == v && traceValue == v
+		},
+		"complex64": func(v complex64) bool {
+			logValue, traceValue := encodeField(v)
+			parsed, err := strconv.ParseComplex(traceValue.(string), 64)
+			return logValue == v && err == nil && complex64(parsed) == v
+		},
+		"complex128": func(v complex128) bool {
+			logValue, traceValue := encodeField(v)
+			parsed, err := strconv.ParseComplex(traceValue.(string), 128)
+			return logValue == v && err == nil && parsed == v
+		},
+	}
+
+	for name, fn := range checks {
+		test.NoError(quick.Check(fn, nil), name)
+	}
+
+	_, traceValue := encodeField(float32(0.1))
+	test.Equal(0.1, traceValue)
+
+	for _, v := range []float64{math.MaxFloat64, math.SmallestNonzeroFloat64, -math.MaxFloat64, math.Inf(1), math.Inf(-1)} {
+		logValue, traceValue := encodeField(v)
+		test.Equal(v, logValue)
+		test.Equal(v, traceValue)
+	}
+
+	logValue, traceValue := encodeField(math.NaN())
+	test.True(math.IsNaN(logValue.(float64)))
+	test.True(math.IsNaN(traceValue.(float64)))
+}
+
+// encodeNested encodes v as a slice element & struct field, both should match the top-level value
+func encodeNested(v interface{}) bool {
+	top, topTrace := encodeField(v)
+	topJSON, err := json.Marshal(topTrace)
+	if err != nil {
+		return false
+	}
+
+	slice := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(v)), 1, 1)
+	slice.Index(0).Set(reflect.ValueOf(v))
+	obj := reflect.New(reflect.StructOf([]reflect.StructField{{Name: "V", Type: reflect.TypeOf(v)}})).Elem()
+	obj.Field(0).Set(reflect.ValueOf(v))
+
+	sliceValue, sliceTrace := encodeField(slice.Interface())
+	objValue, objTrace := encodeField(obj.Interface())
+
+	return reflect.DeepEqual([]interface{}{top}, sliceValue) && sliceTrace == "["+string(topJSON)+"]" &&
+		reflect.DeepEqual(map[string]interface{}{"V": top}, objValue) && objTrace == `{"V":`+string(topJSON)+`}`
+}
+
+func TestConvertFieldNested(t *testing.T) {
+	test := assert.New(t)
+
+	// no int32 & uint8, []rune & []byte are strings
+	checks := map[string]interface{}{
+		"int":     func(v int) bool { return encodeNested(v) },
+		"int8":    func(v int8) bool { return encodeNested(v) },
+		"int16":   func(v int16) bool { return encodeNested(v) },
+		"int64":   func(v int64) bool { return encodeNested(v) },
+		"uint16":  func(v uint16) bool { return encodeNested(v) },
+		"uint32":  func(v uint32) bool { return encodeNested(v) },
+		"float32": func(v float32) bool { return encodeNested(v) },
+		"float64": func(v float64) bool { return encodeNested(v) },
+		"string":  func(v string) bool { return encodeNested(v) },
+		"bool":    func(v bool) bool { return encodeNested(v) },
+	}
+
+	for name, fn := range checks {
+		test.NoError(quick.Check(fn, nil), name)
+	}
+
+	logValue, traceValue := encodeField([]float32{0.1})
+	test.Equal([]interface{}{float32(0.1)}, logValue)
+	test.Equal("[0.1]", traceValue)
+}
+
+type testLevel int
+
+func TestConvertFieldPointersAndNamedTypes(t *testing.T) {
+	test := assert.New(t)
+
+	check := func(v uint64) bool {
+		logValue, traceValue := encodeField(&v)
+		return logValue == v && traceValue == encodeFieldTrace(v)
+	}
+	test.NoError(quick.Check(check, nil))
+
+	var nilInt *int
+	logValue, traceValue := encodeField(nilInt)
+	test.Nil(logValue)
+	test.Equal("null", traceValue)
+
+	logValue, traceValue = encodeField(testLevel(-3))
+	test.Equal(int64(-3), logValue)
+	test.Equal(int64(-3), traceValue)
+
+	i8 := int8(math.MinInt8)
+	ptr := &i8
+	logValue, traceValue = encodeField(&ptr)
+	test.Equal(int8(math.MinInt8), logValue)
+	test.Equal(int64(math.MinInt8), traceValue)
+}
+
+type testError struct{ msg string }
+
+func (e *testError) Error() string { return e.msg }
+
+func TestConvertFieldNilPointers(t *testing.T) {
+	test := assert.New(t)
+
+	// the methods dereference the nil pointer
+	values := []interface{}{(*url.URL)(nil), (*testError)(nil)}
+	for _, v := range values {
+		logValue, traceValue := encodeField(v)
+		test.Nil(logValue)
+		test.Equal("null", traceValue)
+	}
+
+	var err error = (*testError)(nil)
+	logValue, traceValue := encodeField(err)
+	test.Nil(logValue)
+	test.Equal("null", traceValue)
+
+	logValue, _ = encodeField(&url.URL{Scheme: "https", Host: "example.com"})
+	test.Equal("https://example.com", logValue)
+
+	// nested in slices & maps
+	logValue, traceValue = encodeField([]*url.URL{nil, {Scheme: "https", Host: "example.com"}})
+	test.Equal([]interface{}{nil, "https://example.com"}, logValue)
+	test.Equal(`[null,"https://example.com"]`, traceValue)
+
+	logValue, traceValue = encodeField([]interface{}{(*testError)(nil), (*countingObject)(nil)})
+	test.Equal([]interface{}{nil, nil}, logValue)
+	test.Equal(`[null,null]`, traceValue)
+
+	logValue, traceValue = encodeField(map[string]*testError{"a": nil})
+	test.Equal(map[string]interface{}{"a": nil}, logValue)
+	test.Equal(`{"a":null}`, traceValue)
+}
+
+// countingObject counts its encodings
+type countingObject struct{ calls int }
+
+func (o *countingObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	o.calls++
+	enc.AddInt("calls", o.calls)
+	return nil
+}
+
+func TestTraceJSONOnlyForSpans(t *testing.T) {
+	test := assert.New(t)
+
+	cfg, obs := ObservedConfig("test-1")
+	l, err := New("test-1", cfg)
+	if !test.Nil(err) {
+		return
+	}
+
+	// the observer keeps the fields without encoding them
+	obj := &countingObject{}
+	l.Info("no span").WithField("obj", obj).Send()
+	test.Equal(0, obj.calls)
+
+	ctx, span := trace.StartSpan(context.Background(), "test-context-1")
+	defer span.End()
+
+	obj = &countingObject{}
+	entry := l.Info("span").For(ctx).WithField("obj", obj)
+	entry.Send()
+	test.Equal(1, obj.calls)
+	traceFields := entry.(*logEntry).traceFields
+	test.Equal(`{"calls":1}`, traceFields[len(traceFields)-1].Value())
+	test.Equal(2, obs.Len())
+}
+
+func encodeFieldTrace(value interface{}) interface{} {
+	_, traceValue := encodeField(value)
+	return traceValue
+}
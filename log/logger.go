@@ -2,7 +2,9 @@ package log
 
 import (
 	"errors"
+	"io"
 
+	"go.opencensus.io/trace"
 	"go.uber.org/zap"
 )
 
@@ -20,11 +22,19 @@ var (
 
 // StandardLogger is the default implementation of the Logger interface
 type StandardLogger struct {
-	namespace string
-	isNoop    bool
-	logger    *zap.Logger
+	namespace   string
+	isNoop      bool
+	logger      *zap.Logger
+	traceFields []trace.Attribute
+	closers     []io.Closer
+	dropped     *dropCounter
+	stopReport  func()
+	redactor    *redactor
+	correlation *traceCorrelation
 }
 
+// New wraps zapLogger, which should be built with zap.AddCallerSkip(1) like DefaultConfig
+// so the reported caller is the code calling Entry.Send
 func New(namespace string, zapLogger *zap.Logger, options ...Option) (*StandardLogger, error) {
 	if namespace == "" {
 		return nil, ErrNamespaceMissing
@@ -68,17 +78,89 @@ func (s *StandardLogger) Error(msg string) Entry {
 	return s.newLogEntry(LevelError, msg)
 }
 
+// With returns a child logger with the fields pre-encoded into the zap logger,
+// so they are not converted again on every entry
+func (s *StandardLogger) With(fields ...Field) Logger {
+	if len(fields) == 0 {
+		return s
+	}
+
+	child := s.clone()
+
+	logFields := make([]zap.Field, 0, len(fields))
+	for _, f := range fields {
+		logField, traceField := s.redactor.apply(convertField(f.Key, f.Value))
+		logFields = append(logFields, logField)
+		child.traceFields = append(child.traceFields, traceAttribute(logField, traceField))
+	}
+
+	child.logger = s.logger.With(logFields...)
+
+	return child
+}
+
+// Named returns a child logger with the name appended to the zap logger name
+func (s *StandardLogger) Named(name string) Logger {
+	if name == "" {
+		return s
+	}
+
+	child := s.clone()
+	child.logger = s.logger.Named(name)
+
+	return child
+}
+
+// Close flushes buffered entries & closes sink files/connections
 func (s *StandardLogger) Close() error {
-	return s.logger.Sync()
+	if s.stopReport != nil {
+		s.stopReport()
+		s.stopReport = nil
+	}
+
+	err := s.logger.Sync()
+
+	// reverse order: later options wrap the outputs added by earlier ones
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if cerr := s.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	s.closers = nil
+
+	return err
+}
+
+// closerFn adapts a func to an io.Closer for StandardLogger.closers
+type closerFn func() error
+
+func (f closerFn) Close() error {
+	return f()
 }
 
 func (s *StandardLogger) newLogEntry(level int, msg string) Entry {
 	return &logEntry{
-		namespace: s.namespace,
-		level:     level,
-		message:   msg,
-		isNoop:    s.isNoop,
-		span:      nil,
-		logger:    s.logger,
+		namespace:   s.namespace,
+		level:       level,
+		message:     msg,
+		isNoop:      s.isNoop,
+		span:        nil,
+		logger:      s.logger,
+		redactor:    s.redactor,
+		correlation: s.correlation,
+		// copy so entries never append into the logger's backing array
+		traceFields: append([]trace.Attribute(nil), s.traceFields...),
+	}
+}
+
+func (s *StandardLogger) clone() *StandardLogger {
+	return &StandardLogger{
+		namespace:   s.namespace,
+		isNoop:      s.isNoop,
+		logger:      s.logger,
+		traceFields: append([]trace.Attribute(nil), s.traceFields...),
+		dropped:     s.dropped,
+		redactor:    s.redactor,
+		correlation: s.correlation,
 	}
 }
@@ -0,0 +1,353 @@
+// Package logtest records log entries in memory for asserting logging behaviour in tests
+package logtest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sqrt-7/microwave/log"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// UpdateGoldenEnv rewrites golden files instead of comparing when set to a non-empty value
+const UpdateGoldenEnv = "LOGTEST_UPDATE"
+
+// placeholder values written to golden files instead of values that change between runs
+var goldenPlaceholders = map[string]string{
+	"TraceID":                       "<TraceID>",
+	"SpanID":                        "<SpanID>",
+	"trace_id":                      "<TraceID>",
+	"span_id":                       "<SpanID>",
+	"logging.googleapis.com/trace":  "<TraceID>",
+	"logging.googleapis.com/spanId": "<SpanID>",
+	"errorStack":                    "<errorStack>",
+}
+
+// Recorder is a Logger keeping every entry (from Debug up) in memory
+type Recorder struct {
+	*log.StandardLogger
+	obs     *observer.ObservedLogs
+	traceID traceIDField
+
+	mu         sync.Mutex // normalize logs & takes the normalized entry
+	normalizer *log.StandardLogger
+	normalized *observer.ObservedLogs
+}
+
+// traceIDField is the correlation field holding the trace ID, between prefix & suffix
+type traceIDField struct {
+	key    string
+	prefix string
+	suffix string
+}
+
+// Entry is a recorded log entry
+type Entry struct {
+	Level      int
+	Message    string
+	LoggerName string
+	Time       time.Time
+	Caller     zapcore.EntryCaller
+	Fields     map[string]interface{}
+
+	traceID string
+}
+
+// Entries is a filterable list of recorded entries
+type Entries []Entry
+
+// New creates a Recorder, options are applied as for log.New
+func New(namespace string, options ...log.Option) *Recorder {
+	core, obs := observer.New(zapcore.DebugLevel)
+	zapLogger := zap.New(core,
+		zap.Fields(zap.String("namespace", namespace)),
+		zap.AddCaller(),
+		zap.AddCallerSkip(1),
+	)
+
+	l, err := log.New(namespace, zapLogger, options...)
+	if err != nil {
+		panic(err)
+	}
+
+	// expected values are logged through a plain logger so they are converted like the real ones
+	normCore, normObs := observer.New(zapcore.DebugLevel)
+	normalizer, _ := log.New(namespace, zap.New(normCore))
+
+	return &Recorder{
+		StandardLogger: l,
+		obs:            obs,
+		traceID:        findTraceIDField(l),
+		normalizer:     normalizer,
+		normalized:     normObs,
+	}
+}
+
+// findTraceIDField finds the trace ID in the logger's correlation fields (see log.TraceFormats)
+func findTraceIDField(l *log.StandardLogger) traceIDField {
+	probe := trace.SpanContext{TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}}
+	id := probe.TraceID.String()
+
+	for _, f := range l.TraceFields(probe) {
+		if v, ok := f.Value.(string); ok {
+			if i := strings.Index(v, id); i >= 0 {
+				return traceIDField{key: f.Key, prefix: v[:i], suffix: v[i+len(id):]}
+			}
+		}
+	}
+	return traceIDField{}
+}
+
+// Entries returns every recorded entry in order
+func (r *Recorder) Entries() Entries {
+	logged := r.obs.All()
+	entries := make(Entries, len(logged))
+	for i, e := range logged {
+		entries[i] = Entry{
+			Level:      fromZapLevel(e.Level),
+			Message:    e.Message,
+			LoggerName: e.LoggerName,
+			Time:       e.Time,
+			Caller:     e.Caller,
+			Fields:     e.ContextMap(),
+		}
+		entries[i].traceID = r.traceID.value(entries[i].Fields)
+	}
+	return entries
+}
+
+// Reset discards the recorded entries
+func (r *Recorder) Reset() {
+	r.obs.TakeAll()
+}
+
+// Count returns the number of entries with the level & message
+func (r *Recorder) Count(level int, msg string) int {
+	return r.Entries().Level(level).Message(msg).Len()
+}
+
+// HasEntry reports (failing t if not) whether an entry with the level & message
+// contains all the given fields
+func (r *Recorder) HasEntry(t testing.TB, level int, msg string, fields ...log.Field) bool {
+	t.Helper()
+
+	expected := r.normalize(fields...)
+	candidates := r.Entries().Level(level).Message(msg)
+	for _, e := range candidates {
+		if containsFields(e.Fields, expected) {
+			return true
+		}
+	}
+
+	t.Errorf("logtest: no %s entry %q with fields %v\nrecorded:\n%s", levelName(level), msg, expected, r.dump())
+	return false
+}
+
+// FieldEquals reports (failing t if not) whether the entry has the field with the value
+func (r *Recorder) FieldEquals(t testing.TB, e Entry, key string, value interface{}) bool {
+	t.Helper()
+
+	expected := r.normalize(log.F(key, value))
+	actual, ok := e.Fields[key]
+	if ok && reflect.DeepEqual(expected[key], actual) {
+		return true
+	}
+
+	if !ok {
+		t.Errorf("logtest: entry %q has no field %q (fields %v)", e.Message, key, e.Fields)
+	} else {
+		t.Errorf("logtest: entry %q field %q = %#v, expected %#v", e.Message, key, actual, expected[key])
+	}
+	return false
+}
+
+// Golden compares the recorded entries as JSON lines with the file at path,
+// timestamps, trace IDs & stack traces are replaced with placeholders
+func (r *Recorder) Golden(t testing.TB, path string) bool {
+	t.Helper()
+
+	actual, err := r.goldenJSON()
+	if err != nil {
+		t.Errorf("logtest: encoding entries: %v", err)
+		return false
+	}
+
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Errorf("logtest: %v", err)
+			return false
+		}
+		if err := ioutil.WriteFile(path, actual, 0644); err != nil {
+			t.Errorf("logtest: %v", err)
+			return false
+		}
+		return true
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("logtest: reading golden file (set %s=1 to create it): %v", UpdateGoldenEnv, err)
+		return false
+	}
+
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("logtest: entries differ from %s (set %s=1 to update)\nexpected:\n%s\nactual:\n%s", path, UpdateGoldenEnv, expected, actual)
+		return false
+	}
+
+	return true
+}
+
+func (r *Recorder) goldenJSON() ([]byte, error) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.CallerKey = zapcore.OmitKey
+	cfg.EncodeTime = func(_ time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		enc.AppendString("<ts>")
+	}
+	enc := zapcore.NewJSONEncoder(cfg)
+
+	var buf bytes.Buffer
+	for _, e := range r.obs.All() {
+		fields := make([]zapcore.Field, len(e.Context))
+		for i, f := range e.Context {
+			if placeholder, ok := goldenPlaceholders[f.Key]; ok {
+				f = zap.String(f.Key, placeholder)
+			} else if f.Type == zapcore.TimeType || f.Type == zapcore.TimeFullType {
+				f = zap.String(f.Key, "<time>")
+			}
+			fields[i] = f
+		}
+
+		ent := e.Entry
+		ent.Caller = zapcore.EntryCaller{}
+		ent.Stack = ""
+
+		line, err := enc.EncodeEntry(ent, fields)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line.Bytes())
+		line.Free()
+	}
+
+	return buf.Bytes(), nil
+}
+
+// normalize converts expected fields exactly like the logger converts real ones
+func (r *Recorder) normalize(fields ...log.Field) map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry := r.normalizer.Debug("normalize")
+	for _, f := range fields {
+		entry.WithField(f.Key, f.Value)
+	}
+	entry.Send()
+
+	logged := r.normalized.TakeAll()
+	return logged[len(logged)-1].ContextMap()
+}
+
+func (r *Recorder) dump() string {
+	var sb strings.Builder
+	for _, e := range r.Entries() {
+		fmt.Fprintf(&sb, "  %s %q %v\n", levelName(e.Level), e.Message, e.Fields)
+	}
+	return sb.String()
+}
+
+// Level keeps the entries with the level
+func (e Entries) Level(level int) Entries {
+	return e.filter(func(entry Entry) bool { return entry.Level == level })
+}
+
+// Message keeps the entries with the message
+func (e Entries) Message(msg string) Entries {
+	return e.filter(func(entry Entry) bool { return entry.Message == msg })
+}
+
+// Trace keeps the entries attached (with For) to the trace
+func (e Entries) Trace(traceID string) Entries {
+	return e.filter(func(entry Entry) bool { return entry.TraceID() == traceID })
+}
+
+// Field keeps the entries having the field, whatever its value
+func (e Entries) Field(key string) Entries {
+	return e.filter(func(entry Entry) bool {
+		_, ok := entry.Fields[key]
+		return ok
+	})
+}
+
+func (e Entries) Len() int {
+	return len(e)
+}
+
+func (e Entries) filter(match func(Entry) bool) Entries {
+	var filtered Entries
+	for _, entry := range e {
+		if match(entry) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// TraceID returns the trace ID of the correlation fields added by For, or ""
+func (e Entry) TraceID() string {
+	return e.traceID
+}
+
+func (f traceIDField) value(fields map[string]interface{}) string {
+	v, _ := fields[f.key].(string)
+	if f.key == "" || len(v) <= len(f.prefix)+len(f.suffix) || !strings.HasPrefix(v, f.prefix) || !strings.HasSuffix(v, f.suffix) {
+		return ""
+	}
+	return v[len(f.prefix) : len(v)-len(f.suffix)]
+}
+
+func containsFields(actual, expected map[string]interface{}) bool {
+	for k, v := range expected {
+		if a, ok := actual[k]; !ok || !reflect.DeepEqual(a, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func fromZapLevel(level zapcore.Level) int {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return log.LevelDebug
+	case level == zapcore.InfoLevel:
+		return log.LevelInfo
+	case level == zapcore.WarnLevel:
+		return log.LevelWarning
+	default:
+		return log.LevelError
+	}
+}
+
+func levelName(level int) string {
+	switch level {
+	case log.LevelDebug:
+		return "debug"
+	case log.LevelInfo:
+		return "info"
+	case log.LevelWarning:
+		return "warning"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,119 @@
+package logtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sqrt-7/microwave/log"
+	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/trace"
+)
+
+// fakeTB records the failures reported by the helpers
+type fakeTB struct {
+	testing.TB
+	errors []string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecorder(t *testing.T) {
+	test := assert.New(t)
+
+	rec := New("test-1")
+	var logger log.Logger = rec
+
+	ctx, span := trace.StartSpan(context.Background(), "test-context-1")
+	defer span.End()
+
+	logger.Debug("starting").Send()
+	logger.With(log.F("component", "consumer")).Info("hello there").
+		For(ctx).
+		WithField("one", 1).
+		WithField("six", []string{"A", "B", "C"}).
+		Send()
+	logger.Error("failed").WithError(errors.New("boom")).Send()
+
+	test.Equal(3, rec.Entries().Len())
+	test.Equal(1, rec.Count(log.LevelDebug, "starting"))
+	test.Equal(0, rec.Count(log.LevelInfo, "starting"))
+
+	test.True(rec.HasEntry(t, log.LevelInfo, "hello there",
+		log.F("component", "consumer"),
+		log.F("one", 1),
+		log.F("six", []string{"A", "B", "C"}),
+	))
+	test.True(rec.HasEntry(t, log.LevelError, "failed", log.F("error", "boom")))
+
+	traced := rec.Entries().Trace(span.SpanContext().TraceID.String())
+	if test.Equal(1, traced.Len()) {
+		test.True(rec.FieldEquals(t, traced[0], "one", 1))
+	}
+
+	// failures are reported on the given testing.TB
+	mock := &fakeTB{}
+	test.False(rec.HasEntry(mock, log.LevelInfo, "hello there", log.F("one", 2)))
+	test.False(rec.FieldEquals(mock, traced[0], "missing", 1))
+	test.Len(mock.errors, 2)
+
+	rec.Reset()
+	test.Equal(0, rec.Entries().Len())
+}
+
+func TestRecorderTraceFormat(t *testing.T) {
+	test := assert.New(t)
+
+	rec := New("test-1", log.TraceFormats(log.GCPTraceFormat("my-project")))
+
+	ctx, span := trace.StartSpan(context.Background(), "test-context-1")
+	defer span.End()
+
+	rec.Info("hello there").For(ctx).Send()
+	rec.Info("untraced").Send()
+
+	traceID := span.SpanContext().TraceID.String()
+	traced := rec.Entries().Trace(traceID)
+	if test.Equal(1, traced.Len()) {
+		test.Equal("hello there", traced[0].Message)
+		test.Equal(traceID, traced[0].TraceID())
+	}
+}
+
+func TestRecorderParallel(t *testing.T) {
+	rec := New("test-1")
+	for i := 0; i < 4; i++ {
+		rec.Info("hello there").WithField("i", i).Send()
+	}
+
+	// each subtest expects its own field, normalized concurrently
+	for i := 0; i < 4; i++ {
+		i := i
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			for j := 0; j < 200; j++ {
+				rec.HasEntry(t, log.LevelInfo, "hello there", log.F("i", i))
+			}
+		})
+	}
+}
+
+func TestGolden(t *testing.T) {
+	rec := New("test-1")
+
+	ctx, span := trace.StartSpan(context.Background(), "test-context-1")
+	defer span.End()
+
+	rec.Info("hello there").For(ctx).WithField("one", 1).WithField("at", time.Now()).Send()
+	rec.Named("worker").Warning("careful").WithField("six", []string{"A", "B", "C"}).Send()
+	rec.Error("failed").WithError(errors.New("boom")).Send()
+
+	rec.Golden(t, filepath.Join("testdata", "golden.jsonl"))
+}
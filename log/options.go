@@ -23,10 +23,16 @@ func Noop() Option {
 	})
 }
 
+// Caller enables or disables the caller (file:line) & function fields
+func Caller(enabled bool) Option {
+	return optionFn(func(input *StandardLogger) {
+		input.logger = input.logger.WithOptions(zap.WithCaller(enabled))
+	})
+}
+
 func DefaultConfig(namespace string) *zap.Logger {
 	conf := zap.NewProductionConfig()
-	conf.EncoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
-	conf.EncoderConfig.FunctionKey = "func"
+	conf.EncoderConfig = defaultEncoderConfig()
 	conf.OutputPaths = []string{"stdout"}
 	conf.ErrorOutputPaths = []string{"stderr"}
 	conf.Development = false
@@ -43,8 +49,7 @@ func ObservedConfig(namespace string) (*zap.Logger, *observer.ObservedLogs) {
 	wrappedCore, obs := observer.New(zap.NewAtomicLevelAt(zap.InfoLevel))
 
 	conf := zap.NewProductionConfig()
-	conf.EncoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
-	conf.EncoderConfig.FunctionKey = "func"
+	conf.EncoderConfig = defaultEncoderConfig()
 	conf.OutputPaths = []string{"stdout"}
 	conf.ErrorOutputPaths = []string{"stderr"}
 	conf.Development = false
@@ -59,3 +64,10 @@ func ObservedConfig(namespace string) (*zap.Logger, *observer.ObservedLogs) {
 
 	return zapLogger, obs
 }
+
+func defaultEncoderConfig() zapcore.EncoderConfig {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	cfg.FunctionKey = "func"
+	return cfg
+}
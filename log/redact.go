@@ -0,0 +1,461 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const DefaultRedactMask = "[REDACTED]"
+
+// RedactPattern masks every match of Regexp in string values,
+// Match (optional) filters out false positives
+type RedactPattern struct {
+	Regexp *regexp.Regexp
+	Match  func(string) bool
+}
+
+var (
+	// CreditCardPattern matches 13-19 digit card numbers (optionally space/dash separated) passing the Luhn check
+	CreditCardPattern = RedactPattern{
+		Regexp: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		Match:  luhnValid,
+	}
+
+	// JWTPattern matches JSON Web Tokens
+	JWTPattern = RedactPattern{
+		Regexp: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	}
+
+	// EmailPattern matches email addresses
+	EmailPattern = RedactPattern{
+		Regexp: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	}
+)
+
+// RedactConfig configures which log fields are masked
+type RedactConfig struct {
+	Keys     []string        // mask the whole value of fields whose key contains one of these as whole words (case-insensitive, split on separators & camelCase: "token" matches access_token & AccessToken, not tokenizer)
+	Patterns []RedactPattern // mask matches inside string values, including the strings of raw JSON
+	Mask     string          // replacement, defaults to DefaultRedactMask
+}
+
+func DefaultRedactConfig() RedactConfig {
+	return RedactConfig{
+		Keys:     []string{"password", "token", "authorization", "email"},
+		Patterns: []RedactPattern{CreditCardPattern, JWTPattern, EmailPattern},
+		Mask:     DefaultRedactMask,
+	}
+}
+
+// Redact masks sensitive fields (including nested ones & fields added with Logger.With)
+// before they reach zap or the trace span
+func Redact(cfg RedactConfig) Option {
+	return optionFn(func(input *StandardLogger) {
+		input.redactor = newRedactor(cfg)
+	})
+}
+
+type redactor struct {
+	keys     [][]string
+	patterns []RedactPattern
+	mask     string
+}
+
+func newRedactor(cfg RedactConfig) *redactor {
+	r := &redactor{
+		keys:     make([][]string, 0, len(cfg.Keys)),
+		patterns: cfg.Patterns,
+		mask:     cfg.Mask,
+	}
+	if r.mask == "" {
+		r.mask = DefaultRedactMask
+	}
+	for _, k := range cfg.Keys {
+		if segments := keySegments(k); len(segments) > 0 {
+			r.keys = append(r.keys, segments)
+		}
+	}
+	return r
+}
+
+// apply redacts a converted field, a nil redactor returns it unchanged
+func (r *redactor) apply(logField zap.Field, traceField trace.Attribute) (zap.Field, trace.Attribute) {
+	if r == nil {
+		return logField, traceField
+	}
+
+	key := logField.Key
+	if r.sensitive(key) {
+		return zap.String(key, r.mask), trace.StringAttribute(key, r.mask)
+	}
+
+	switch logField.Type {
+	case zapcore.StringType:
+		v := r.redactString(logField.String)
+		return zap.String(key, v), trace.StringAttribute(key, v)
+	case zapcore.ObjectMarshalerType:
+		return zap.Object(key, redactedObject{ObjectMarshaler: logField.Interface.(zapcore.ObjectMarshaler), r: r}), trace.Attribute{}
+	case zapcore.ArrayMarshalerType:
+		return zap.Array(key, redactedArray{ArrayMarshaler: logField.Interface.(zapcore.ArrayMarshaler), r: r}), trace.Attribute{}
+	case zapcore.ReflectType:
+		if raw, ok := logField.Interface.(json.RawMessage); ok {
+			v := r.redactJSON(raw)
+			return zap.Reflect(key, v), trace.StringAttribute(key, string(v))
+		}
+	}
+
+	return logField, traceField
+}
+
+func (r *redactor) sensitive(key string) bool {
+	segments := keySegments(key)
+	for _, k := range r.keys {
+		if containsSegments(segments, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// keySegments splits a key into lowercase words, on non-alphanumeric characters & camelCase boundaries
+func keySegments(key string) []string {
+	var segments []string
+	runes := []rune(key)
+	start := -1
+	for i, c := range runes {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			if start >= 0 {
+				segments = append(segments, strings.ToLower(string(runes[start:i])))
+				start = -1
+			}
+			continue
+		}
+
+		// userName & APIKey
+		if start >= 0 && unicode.IsUpper(c) &&
+			(unicode.IsLower(runes[i-1]) || unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			segments = append(segments, strings.ToLower(string(runes[start:i])))
+			start = i
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		segments = append(segments, strings.ToLower(string(runes[start:])))
+	}
+	return segments
+}
+
+// containsSegments reports whether sub appears as consecutive segments of segments
+func containsSegments(segments, sub []string) bool {
+	for i := 0; i+len(sub) <= len(segments); i++ {
+		match := true
+		for j := range sub {
+			if segments[i+j] != sub[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// redactJSON masks the sensitive keys & string values of raw JSON (e.g. protojson), numbers are kept.
+// Invalid JSON is redacted as a string
+func (r *redactor) redactJSON(raw json.RawMessage) json.RawMessage {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return json.RawMessage(r.redactString(string(raw)))
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(r.redactValue(v)); err != nil {
+		return json.RawMessage(r.redactString(string(raw)))
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// redactValue redacts a decoded JSON value in place
+func (r *redactor) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return r.redactString(v)
+	case map[string]interface{}:
+		for k, val := range v {
+			if r.sensitive(k) {
+				v[k] = r.mask
+			} else {
+				v[k] = r.redactValue(val)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = r.redactValue(v[i])
+		}
+	}
+	return v
+}
+
+func (r *redactor) redactString(s string) string {
+	for _, p := range r.patterns {
+		if p.Regexp == nil {
+			continue
+		}
+		s = p.Regexp.ReplaceAllStringFunc(s, func(match string) string {
+			if p.Match != nil && !p.Match(match) {
+				return match
+			}
+			return r.mask
+		})
+	}
+	return s
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+type redactedObject struct {
+	zapcore.ObjectMarshaler
+	r *redactor
+}
+
+func (o redactedObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return o.ObjectMarshaler.MarshalLogObject(&redactingObjectEncoder{ObjectEncoder: enc, r: o.r})
+}
+
+type redactedArray struct {
+	zapcore.ArrayMarshaler
+	r *redactor
+}
+
+func (a redactedArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	return a.ArrayMarshaler.MarshalLogArray(&redactingArrayEncoder{ArrayEncoder: enc, r: a.r})
+}
+
+// redactingObjectEncoder masks sensitive keys & string values of nested objects
+type redactingObjectEncoder struct {
+	zapcore.ObjectEncoder
+	r *redactor
+}
+
+// masked writes the mask instead of the value if the key is sensitive
+func (e *redactingObjectEncoder) masked(key string) bool {
+	if e.r.sensitive(key) {
+		e.ObjectEncoder.AddString(key, e.r.mask)
+		return true
+	}
+	return false
+}
+
+func (e *redactingObjectEncoder) AddArray(key string, v zapcore.ArrayMarshaler) error {
+	if e.masked(key) {
+		return nil
+	}
+	return e.ObjectEncoder.AddArray(key, redactedArray{ArrayMarshaler: v, r: e.r})
+}
+
+func (e *redactingObjectEncoder) AddObject(key string, v zapcore.ObjectMarshaler) error {
+	if e.masked(key) {
+		return nil
+	}
+	return e.ObjectEncoder.AddObject(key, redactedObject{ObjectMarshaler: v, r: e.r})
+}
+
+func (e *redactingObjectEncoder) AddReflected(key string, v interface{}) error {
+	if e.masked(key) {
+		return nil
+	}
+	if raw, ok := v.(json.RawMessage); ok {
+		v = e.r.redactJSON(raw)
+	}
+	return e.ObjectEncoder.AddReflected(key, v)
+}
+
+func (e *redactingObjectEncoder) AddString(key, v string) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddString(key, e.r.redactString(v))
+	}
+}
+
+func (e *redactingObjectEncoder) AddByteString(key string, v []byte) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddString(key, e.r.redactString(string(v)))
+	}
+}
+
+func (e *redactingObjectEncoder) AddBinary(key string, v []byte) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddBinary(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddBool(key string, v bool) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddBool(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddComplex128(key string, v complex128) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddComplex128(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddComplex64(key string, v complex64) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddComplex64(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddDuration(key string, v time.Duration) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddDuration(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddFloat64(key string, v float64) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddFloat64(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddFloat32(key string, v float32) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddFloat32(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddInt(key string, v int) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddInt(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddInt64(key string, v int64) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddInt64(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddInt32(key string, v int32) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddInt32(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddInt16(key string, v int16) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddInt16(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddInt8(key string, v int8) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddInt8(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddTime(key string, v time.Time) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddTime(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddUint(key string, v uint) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddUint(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddUint64(key string, v uint64) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddUint64(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddUint32(key string, v uint32) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddUint32(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddUint16(key string, v uint16) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddUint16(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddUint8(key string, v uint8) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddUint8(key, v)
+	}
+}
+
+func (e *redactingObjectEncoder) AddUintptr(key string, v uintptr) {
+	if !e.masked(key) {
+		e.ObjectEncoder.AddUintptr(key, v)
+	}
+}
+
+// redactingArrayEncoder masks string values & nested objects of arrays
+type redactingArrayEncoder struct {
+	zapcore.ArrayEncoder
+	r *redactor
+}
+
+func (e *redactingArrayEncoder) AppendArray(v zapcore.ArrayMarshaler) error {
+	return e.ArrayEncoder.AppendArray(redactedArray{ArrayMarshaler: v, r: e.r})
+}
+
+func (e *redactingArrayEncoder) AppendObject(v zapcore.ObjectMarshaler) error {
+	return e.ArrayEncoder.AppendObject(redactedObject{ObjectMarshaler: v, r: e.r})
+}
+
+func (e *redactingArrayEncoder) AppendReflected(v interface{}) error {
+	if raw, ok := v.(json.RawMessage); ok {
+		v = e.r.redactJSON(raw)
+	}
+	return e.ArrayEncoder.AppendReflected(v)
+}
+
+func (e *redactingArrayEncoder) AppendString(v string) {
+	e.ArrayEncoder.AppendString(e.r.redactString(v))
+}
+
+func (e *redactingArrayEncoder) AppendByteString(v []byte) {
+	e.ArrayEncoder.AppendString(e.r.redactString(string(v)))
+}
@@ -0,0 +1,282 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxReflectDepth guards against cyclic or very deep values
+const maxReflectDepth = 10
+
+var errMaxDepth = errors.New("max depth exceeded")
+
+// reflectObject encodes maps & structs as nested log objects
+type reflectObject struct {
+	value reflect.Value
+	depth int
+}
+
+// reflectArray encodes slices & arrays as log arrays
+type reflectArray struct {
+	value reflect.Value
+	depth int
+}
+
+// reflectField returns an Object/Array field for maps, structs, slices & arrays
+// (or pointers to them), ok is false for anything else
+func reflectField(key string, value interface{}, depth int) (zap.Field, bool) {
+	v, ok := composite(value)
+	if !ok {
+		return zap.Field{}, false
+	}
+
+	switch v.Kind() {
+	case reflect.Map, reflect.Struct:
+		return zap.Object(key, reflectObject{value: v, depth: depth}), true
+	default:
+		return zap.Array(key, reflectArray{value: v, depth: depth}), true
+	}
+}
+
+func (r reflectObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if r.depth >= maxReflectDepth {
+		return errMaxDepth
+	}
+
+	if r.value.Kind() == reflect.Map {
+		keys := r.value.MapKeys()
+		names := make([]string, len(keys))
+		for i, k := range keys {
+			names[i] = fmt.Sprint(k.Interface())
+		}
+		sort.Sort(keysByName{keys: keys, names: names})
+
+		for i, k := range keys {
+			nestedField(names[i], r.value.MapIndex(k), r.depth+1).AddTo(enc)
+		}
+		return nil
+	}
+
+	t := r.value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+
+		name := sf.Name
+		if tag, ok := sf.Tag.Lookup("json"); ok {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+
+		nestedField(name, r.value.Field(i), r.depth+1).AddTo(enc)
+	}
+
+	return nil
+}
+
+func (r reflectArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	if r.depth >= maxReflectDepth {
+		return errMaxDepth
+	}
+
+	for i := 0; i < r.value.Len(); i++ {
+		if err := appendNested(enc, r.value.Index(i), r.depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nestedField converts a map value or struct field without building trace attributes for composites
+func nestedField(key string, v reflect.Value, depth int) zap.Field {
+	if !v.IsValid() || !v.CanInterface() {
+		return zap.Reflect(key, nil)
+	}
+
+	value := v.Interface()
+	if !isKnownType(value) {
+		if f, ok := reflectField(key, value, depth); ok {
+			return f
+		}
+	}
+
+	logField, _ := convertField(key, value)
+	return logField
+}
+
+func appendNested(enc zapcore.ArrayEncoder, v reflect.Value, depth int) error {
+	if !v.IsValid() || !v.CanInterface() {
+		return enc.AppendReflected(nil)
+	}
+
+	// typed nil pointers are null, their Error, String & Marshal methods may panic
+	if rv := reflect.ValueOf(v.Interface()); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return enc.AppendReflected(nil)
+	}
+
+	switch value := v.Interface().(type) {
+	case nil:
+		return enc.AppendReflected(nil)
+	case zapcore.ObjectMarshaler:
+		return enc.AppendObject(value)
+	case zapcore.ArrayMarshaler:
+		return enc.AppendArray(value)
+	case []byte:
+		enc.AppendString(string(value))
+		return nil
+	case time.Time:
+		enc.AppendTime(value)
+		return nil
+	case time.Duration:
+		enc.AppendDuration(value)
+		return nil
+	case proto.Message:
+		return enc.AppendReflected(protoJSON(value))
+	case error:
+		enc.AppendString(value.Error())
+		return nil
+	case fmt.Stringer:
+		enc.AppendString(value.String())
+		return nil
+	}
+
+	if c, ok := composite(v.Interface()); ok {
+		if c.Kind() == reflect.Map || c.Kind() == reflect.Struct {
+			return enc.AppendObject(reflectObject{value: c, depth: depth})
+		}
+		return enc.AppendArray(reflectArray{value: c, depth: depth})
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return enc.AppendReflected(nil)
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		enc.AppendString(v.String())
+	case reflect.Bool:
+		enc.AppendBool(v.Bool())
+	// sized like the top-level fields, so float32 values keep their shortest form
+	case reflect.Int, reflect.Int64:
+		enc.AppendInt64(v.Int())
+	case reflect.Int8:
+		enc.AppendInt8(int8(v.Int()))
+	case reflect.Int16:
+		enc.AppendInt16(int16(v.Int()))
+	case reflect.Int32:
+		enc.AppendInt32(int32(v.Int()))
+	case reflect.Uint, reflect.Uint64:
+		enc.AppendUint64(v.Uint())
+	case reflect.Uint8:
+		enc.AppendUint8(uint8(v.Uint()))
+	case reflect.Uint16:
+		enc.AppendUint16(uint16(v.Uint()))
+	case reflect.Uint32:
+		enc.AppendUint32(uint32(v.Uint()))
+	case reflect.Uintptr:
+		enc.AppendUintptr(uintptr(v.Uint()))
+	case reflect.Float32:
+		enc.AppendFloat32(float32(v.Float()))
+	case reflect.Float64:
+		enc.AppendFloat64(v.Float())
+	case reflect.Complex64:
+		enc.AppendComplex64(complex64(v.Complex()))
+	case reflect.Complex128:
+		enc.AppendComplex128(v.Complex())
+	default:
+		enc.AppendString(fmt.Sprint(v.Interface()))
+	}
+
+	return nil
+}
+
+// composite dereferences value and reports whether it is a map, struct, slice or array
+func composite(value interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map, reflect.Struct, reflect.Slice, reflect.Array:
+		return v, true
+	}
+
+	return v, false
+}
+
+// isKnownType reports whether convertField has a dedicated case for value
+func isKnownType(value interface{}) bool {
+	switch value.(type) {
+	case []byte, []rune, zapcore.ObjectMarshaler, zapcore.ArrayMarshaler,
+		time.Time, time.Duration, proto.Message, error, fmt.Stringer:
+		return true
+	}
+	return false
+}
+
+// protoJSON encodes a proto message, falling back to its text form
+func protoJSON(m proto.Message) json.RawMessage {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(m))
+	}
+	return b
+}
+
+// traceAttribute returns traceField, or the JSON of a composite logField (zero traceField)
+func traceAttribute(logField zap.Field, traceField trace.Attribute) trace.Attribute {
+	if traceField.Value() != nil {
+		return traceField
+	}
+	return trace.StringAttribute(logField.Key, fieldJSON(logField))
+}
+
+// fieldJSON encodes a log field's value as JSON for use as a trace attribute
+func fieldJSON(f zap.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+
+	b, err := json.Marshal(enc.Fields[f.Key])
+	if err != nil {
+		return fmt.Sprint(enc.Fields[f.Key])
+	}
+	return string(b)
+}
+
+type keysByName struct {
+	keys  []reflect.Value
+	names []string
+}
+
+func (k keysByName) Len() int           { return len(k.keys) }
+func (k keysByName) Less(i, j int) bool { return k.names[i] < k.names[j] }
+func (k keysByName) Swap(i, j int) {
+	k.keys[i], k.keys[j] = k.keys[j], k.keys[i]
+	k.names[i], k.names[j] = k.names[j], k.names[i]
+}
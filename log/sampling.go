@@ -0,0 +1,250 @@
+package log
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	MsgLogEntriesDropped = "LOG_ENTRIES_DROPPED"
+
+	// maxRateLimitKeys bounds the limiter state if messages are not constant
+	maxRateLimitKeys = 4096
+)
+
+// SamplingConfig logs the First entries per level & message in each Interval,
+// then every Thereafter-th entry (0 drops the rest)
+type SamplingConfig struct {
+	Interval   time.Duration
+	First      int
+	Thereafter int
+}
+
+// RateLimitConfig allows at most Limit entries per level & message in each Interval
+type RateLimitConfig struct {
+	Interval time.Duration
+	Limit    int
+}
+
+// Sampling drops repeated entries using zap's sampler, dropped entries are counted for DroppedReport
+func Sampling(cfg SamplingConfig) Option {
+	return optionFn(func(input *StandardLogger) {
+		if cfg.Interval <= 0 || cfg.First <= 0 {
+			return
+		}
+
+		// zap divides by thereafter, so "drop the rest" becomes a step that is never reached
+		thereafter := cfg.Thereafter
+		if thereafter <= 0 {
+			thereafter = math.MaxInt32
+		}
+
+		dropped := input.dropCounter()
+		input.logger = input.logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewSamplerWithOptions(core, cfg.Interval, cfg.First, thereafter,
+				zapcore.SamplerHook(func(ent zapcore.Entry, dec zapcore.SamplingDecision) {
+					if dec&zapcore.LogDropped > 0 {
+						dropped.add(ent.Message)
+					}
+				}),
+			)
+		}))
+	})
+}
+
+// RateLimit drops entries above the per-message limit, dropped entries are counted for DroppedReport
+func RateLimit(cfg RateLimitConfig) Option {
+	return optionFn(func(input *StandardLogger) {
+		if cfg.Interval <= 0 || cfg.Limit <= 0 {
+			return
+		}
+
+		limiter := &rateLimiter{
+			interval: cfg.Interval,
+			limit:    cfg.Limit,
+			windows:  make(map[rateLimitKey]*rateLimitWindow),
+			dropped:  input.dropCounter(),
+		}
+		input.logger = input.logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return &rateLimitCore{Core: core, limiter: limiter}
+		}))
+	})
+}
+
+// DroppedReport logs a LOG_ENTRIES_DROPPED summary every interval in which
+// Sampling or RateLimit dropped entries, until the logger is closed.
+// The summary is always written, whatever the min level & the limits (the last DroppedReport applies)
+func DroppedReport(interval time.Duration) Option {
+	return optionFn(func(input *StandardLogger) {
+		if interval <= 0 {
+			return
+		}
+
+		if input.stopReport != nil {
+			input.stopReport()
+			input.stopReport = nil
+		}
+
+		dropped := input.dropCounter()
+		stop := make(chan struct{})
+		done := make(chan struct{})
+
+		// stopped before the closers, the final summary is written while the sinks are open
+		input.stopReport = func() {
+			close(stop)
+			<-done
+		}
+
+		go func() {
+			defer close(done)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					reportDropped(input, dropped)
+				case <-stop:
+					reportDropped(input, dropped)
+					return
+				}
+			}
+		}()
+	})
+}
+
+// reportDropped writes the summary to the core, without the level check of the loggers
+// & the limits of Sampling & RateLimit (they only apply to checked entries)
+func reportDropped(l *StandardLogger, dropped *dropCounter) {
+	counts, total := dropped.flush()
+	if total == 0 {
+		return
+	}
+
+	countsField, _ := convertField("dropped", counts)
+	ent := zapcore.Entry{Level: zapcore.WarnLevel, Time: time.Now(), Message: MsgLogEntriesDropped}
+	_ = l.logger.Core().Write(ent, []zap.Field{countsField, zap.Uint64("total", total)})
+}
+
+func (s *StandardLogger) dropCounter() *dropCounter {
+	if s.dropped == nil {
+		s.dropped = &dropCounter{counts: make(map[string]uint64)}
+	}
+	return s.dropped
+}
+
+// dropCounter counts dropped entries per message
+type dropCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (d *dropCounter) add(msg string) {
+	d.mu.Lock()
+	d.counts[msg]++
+	d.mu.Unlock()
+}
+
+func (d *dropCounter) flush() (map[string]uint64, uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var total uint64
+	for _, n := range d.counts {
+		total += n
+	}
+
+	counts := d.counts
+	d.counts = make(map[string]uint64)
+
+	return counts, total
+}
+
+type rateLimitKey struct {
+	level   zapcore.Level
+	message string
+}
+
+type rateLimitWindow struct {
+	start time.Time
+	count int
+}
+
+// rateLimiter is a fixed-window counter per level & message, shared by all child cores
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	limit    int
+	windows  map[rateLimitKey]*rateLimitWindow
+	dropped  *dropCounter
+}
+
+func (r *rateLimiter) allow(ent zapcore.Entry) bool {
+	key := rateLimitKey{level: ent.Level, message: ent.Message}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.windows[key]
+	if !ok {
+		if len(r.windows) >= maxRateLimitKeys {
+			r.evict(ent.Time)
+		}
+		w = &rateLimitWindow{start: ent.Time}
+		r.windows[key] = w
+	}
+
+	if ent.Time.Sub(w.start) >= r.interval {
+		w.start = ent.Time
+		w.count = 0
+	}
+
+	w.count++
+	return w.count <= r.limit
+}
+
+// evict deletes the expired windows, or the oldest one if they're all running
+func (r *rateLimiter) evict(now time.Time) {
+	var oldest rateLimitKey
+	var oldestStart time.Time
+
+	for key, w := range r.windows {
+		if now.Sub(w.start) >= r.interval {
+			delete(r.windows, key)
+			continue
+		}
+		if oldestStart.IsZero() || w.start.Before(oldestStart) {
+			oldest, oldestStart = key, w.start
+		}
+	}
+
+	if len(r.windows) >= maxRateLimitKeys {
+		delete(r.windows, oldest)
+	}
+}
+
+type rateLimitCore struct {
+	zapcore.Core
+	limiter *rateLimiter
+}
+
+func (c *rateLimitCore) With(fields []zapcore.Field) zapcore.Core {
+	return &rateLimitCore{Core: c.Core.With(fields), limiter: c.limiter}
+}
+
+func (c *rateLimitCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+
+	if !c.limiter.allow(ent) {
+		c.limiter.dropped.add(ent.Message)
+		return ce
+	}
+
+	return c.Core.Check(ent, ce)
+}
@@ -0,0 +1,141 @@
+package log
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var (
+	ErrSinkWriterMissing   = errors.New("log sink writer missing")
+	ErrSinkFilenameMissing = errors.New("log sink filename missing")
+)
+
+// Sink is a single log output with its own minimum level & encoder
+type Sink struct {
+	level   int
+	encoder zapcore.Encoder
+	newCore func(enc zapcore.Encoder, level zapcore.LevelEnabler) zapcore.Core
+	closer  io.Closer
+}
+
+// FileSinkConfig configures a rotating log file
+type FileSinkConfig struct {
+	Filename   string // path to the active log file
+	MaxSizeMB  int    // rotate once the file reaches this size (default 100)
+	MaxAgeDays int    // delete rotated files older than this (0 keeps them)
+	MaxBackups int    // keep at most this many rotated files (0 keeps all)
+	Compress   bool   // gzip rotated files
+	LocalTime  bool   // use local time in rotated file names instead of UTC
+}
+
+// NewWriterSink writes entries to an arbitrary io.Writer (a nil encoder means JSON)
+func NewWriterSink(w io.Writer, level int, encoder zapcore.Encoder) (*Sink, error) {
+	if w == nil {
+		return nil, ErrSinkWriterMissing
+	}
+
+	return newWriteSyncerSink(zapcore.AddSync(w), level, encoder), nil
+}
+
+// NewStdoutSink writes entries to stdout (a nil encoder means JSON)
+func NewStdoutSink(level int, encoder zapcore.Encoder) *Sink {
+	return newWriteSyncerSink(zapcore.Lock(os.Stdout), level, encoder)
+}
+
+// NewFileSink writes entries to a file rotated by size, pruned by age & count (a nil encoder means JSON)
+func NewFileSink(cfg FileSinkConfig, level int, encoder zapcore.Encoder) (*Sink, error) {
+	if cfg.Filename == "" {
+		return nil, ErrSinkFilenameMissing
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Filename), 0755); err != nil {
+		return nil, err
+	}
+
+	rotator := &lumberjack.Logger{
+		Filename:   cfg.Filename,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxAge:     cfg.MaxAgeDays,
+		MaxBackups: cfg.MaxBackups,
+		Compress:   cfg.Compress,
+		LocalTime:  cfg.LocalTime,
+	}
+
+	s := newWriteSyncerSink(zapcore.AddSync(rotator), level, encoder)
+	s.closer = rotator
+
+	return s, nil
+}
+
+func newWriteSyncerSink(ws zapcore.WriteSyncer, level int, encoder zapcore.Encoder) *Sink {
+	return &Sink{
+		level:   level,
+		encoder: encoder,
+		newCore: func(enc zapcore.Encoder, lvl zapcore.LevelEnabler) zapcore.Core {
+			return zapcore.NewCore(enc, ws, lvl)
+		},
+	}
+}
+
+// core builds the zap core for the sink, tagged with the logger namespace
+func (s *Sink) core(namespace string) zapcore.Core {
+	enc := s.encoder
+	if enc == nil {
+		enc = NewJSONEncoder()
+	}
+
+	return s.newCore(enc, zapLevel(s.level)).With([]zap.Field{zap.String("namespace", namespace)})
+}
+
+// Sinks replaces the logger output with the given sinks
+// (fields added to the zap logger before New are dropped)
+func Sinks(sinks ...*Sink) Option {
+	return optionFn(func(input *StandardLogger) {
+		input.logger = input.logger.WithOptions(zap.WrapCore(func(zapcore.Core) zapcore.Core {
+			return sinkCores(input, sinks)
+		}))
+	})
+}
+
+// AddSinks writes to the given sinks in addition to the existing logger output
+func AddSinks(sinks ...*Sink) Option {
+	return optionFn(func(input *StandardLogger) {
+		input.logger = input.logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(core, sinkCores(input, sinks))
+		}))
+	})
+}
+
+func sinkCores(l *StandardLogger, sinks []*Sink) zapcore.Core {
+	cores := make([]zapcore.Core, 0, len(sinks))
+	for _, s := range sinks {
+		if s == nil {
+			continue
+		}
+		cores = append(cores, s.core(l.namespace))
+		if s.closer != nil {
+			l.closers = append(l.closers, s.closer)
+		}
+	}
+
+	return zapcore.NewTee(cores...)
+}
+
+func zapLevel(level int) zapcore.Level {
+	switch level {
+	case LevelDebug:
+		return zapcore.DebugLevel
+	case LevelWarning:
+		return zapcore.WarnLevel
+	case LevelError:
+		return zapcore.ErrorLevel
+	default:
+		return zapcore.InfoLevel
+	}
+}
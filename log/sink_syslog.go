@@ -0,0 +1,88 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package log
+
+import (
+	"log/syslog"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// SyslogSinkConfig configures a syslog connection
+type SyslogSinkConfig struct {
+	Network  string // "" connects to the local syslog socket, otherwise eg. "udp" or "unixgram"
+	Address  string // socket path or host:port (ignored when Network is empty)
+	Tag      string // program tag, defaults to the process name
+	Facility syslog.Priority
+}
+
+// NewSyslogSink writes entries to syslog with the severity matching the entry level (a nil encoder means JSON)
+func NewSyslogSink(cfg SyslogSinkConfig, level int, encoder zapcore.Encoder) (*Sink, error) {
+	facility := cfg.Facility
+	if facility == 0 {
+		facility = syslog.LOG_USER
+	}
+
+	w, err := syslog.Dial(cfg.Network, cfg.Address, facility|syslog.LOG_INFO, cfg.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sink{
+		level:   level,
+		encoder: encoder,
+		newCore: func(enc zapcore.Encoder, lvl zapcore.LevelEnabler) zapcore.Core {
+			return &syslogCore{LevelEnabler: lvl, enc: enc, w: w}
+		},
+		closer: w,
+	}, nil
+}
+
+// syslogCore writes each entry with the syslog severity of its level
+type syslogCore struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	w   *syslog.Writer
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &syslogCore{LevelEnabler: c.LevelEnabler, enc: c.enc.Clone(), w: c.w}
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+	}
+	return clone
+}
+
+func (c *syslogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *syslogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	msg := buf.String()
+	switch ent.Level {
+	case zapcore.DebugLevel:
+		return c.w.Debug(msg)
+	case zapcore.InfoLevel:
+		return c.w.Info(msg)
+	case zapcore.WarnLevel:
+		return c.w.Warning(msg)
+	case zapcore.ErrorLevel:
+		return c.w.Err(msg)
+	default:
+		return c.w.Crit(msg)
+	}
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
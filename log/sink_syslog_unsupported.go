@@ -0,0 +1,25 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package log
+
+import (
+	"errors"
+
+	"go.uber.org/zap/zapcore"
+)
+
+var ErrSyslogUnsupported = errors.New("syslog is not supported on this platform")
+
+// SyslogSinkConfig configures a syslog connection
+type SyslogSinkConfig struct {
+	Network  string
+	Address  string
+	Tag      string
+	Facility int
+}
+
+// NewSyslogSink always fails, syslog is not available on this platform
+func NewSyslogSink(cfg SyslogSinkConfig, level int, encoder zapcore.Encoder) (*Sink, error) {
+	return nil, ErrSyslogUnsupported
+}
@@ -0,0 +1,380 @@
+//go:build go1.21
+// +build go1.21
+
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sort"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+var ErrSlogHandlerMissing = errors.New("logger slog handler missing")
+
+// SlogHandler is a slog.Handler writing through a StandardLogger
+// (namespace, trace IDs & context fields are added like For(ctx) does)
+type SlogHandler struct {
+	logger *StandardLogger
+	groups []slogGroupFields
+}
+
+// slogGroupFields holds the fields added with WithAttrs after WithGroup(name)
+type slogGroupFields struct {
+	name   string
+	fields []Field
+}
+
+// NewSlogHandler returns a slog.Handler backed by the logger
+func NewSlogHandler(l *StandardLogger) *SlogHandler {
+	return &SlogHandler{logger: l}
+}
+
+// Slog returns a *slog.Logger backed by the logger
+func (s *StandardLogger) Slog() *slog.Logger {
+	return slog.New(NewSlogHandler(s))
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return !h.logger.isNoop && h.logger.logger.Core().Enabled(zapLevel(fromSlogLevel(level)))
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	e := h.logger.newLogEntry(fromSlogLevel(r.Level), r.Message).(*logEntry)
+	// the record holds the call site of the slog.Logger method & the time of the call
+	e.callerPC = r.PC
+	e.time = r.Time
+	entry := e.For(ctx)
+
+	fields := make([]Field, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		fields = append(fields, slogFields(a)...)
+		return true
+	})
+
+	// nest the record fields under the open groups, innermost first
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		fields = append(append([]Field(nil), h.groups[i].fields...), fields...)
+		if len(fields) == 0 {
+			continue
+		}
+
+		group := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			group[f.Key] = f.Value
+		}
+		fields = []Field{F(h.groups[i].name, group)}
+	}
+
+	for _, f := range fields {
+		entry.WithField(f.Key, f.Value)
+	}
+
+	entry.Send()
+	return nil
+}
+
+// WithAttrs pre-encodes the fields into the logger, unless a group is open
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make([]Field, 0, len(attrs))
+	for _, a := range attrs {
+		fields = append(fields, slogFields(a)...)
+	}
+
+	if len(h.groups) == 0 {
+		return &SlogHandler{logger: h.logger.With(fields...).(*StandardLogger)}
+	}
+
+	groups := append([]slogGroupFields(nil), h.groups...)
+	last := &groups[len(groups)-1]
+	last.fields = append(append([]Field(nil), last.fields...), fields...)
+
+	return &SlogHandler{logger: h.logger, groups: groups}
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	groups := append(append([]slogGroupFields(nil), h.groups...), slogGroupFields{name: name})
+
+	return &SlogHandler{logger: h.logger, groups: groups}
+}
+
+// slogFields converts an attr to fields, inlining groups with an empty key
+func slogFields(a slog.Attr) []Field {
+	key, value, ok := slogField(a)
+	if !ok {
+		return nil
+	}
+
+	if group, isGroup := value.(map[string]interface{}); isGroup && key == "" {
+		fields := make([]Field, 0, len(group))
+		for k, v := range group {
+			fields = append(fields, F(k, v))
+		}
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+		return fields
+	}
+
+	return []Field{F(key, value)}
+}
+
+// slogField resolves an attr to a key & value convertField understands (groups become maps)
+func slogField(a slog.Attr) (string, interface{}, bool) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return "", nil, false
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		group := slogGroup(a.Value.Group())
+		if len(group) == 0 {
+			return "", nil, false
+		}
+		return a.Key, group, true
+	}
+
+	return a.Key, a.Value.Any(), true
+}
+
+func slogGroup(attrs []slog.Attr) map[string]interface{} {
+	group := make(map[string]interface{}, len(attrs))
+	for _, a := range attrs {
+		key, value, ok := slogField(a)
+		if !ok {
+			continue
+		}
+		// groups with an empty key are inlined
+		if inline, isGroup := value.(map[string]interface{}); isGroup && key == "" {
+			for k, v := range inline {
+				group[k] = v
+			}
+			continue
+		}
+		group[key] = value
+	}
+	return group
+}
+
+func fromSlogLevel(level slog.Level) int {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarning
+	default:
+		return LevelError
+	}
+}
+
+func toSlogLevel(level int) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarning:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// SlogLogger is an implementation of the Logger interface writing to any slog.Handler.
+// With a SlogHandler, For is left to the handler's logger (its trace format & span annotations)
+type SlogLogger struct {
+	namespace   string
+	name        string
+	handler     slog.Handler
+	spanHandled bool // the handler correlates & annotates the span of the context
+}
+
+func NewSlogLogger(namespace string, handler slog.Handler) (*SlogLogger, error) {
+	if namespace == "" {
+		return nil, ErrNamespaceMissing
+	}
+
+	if handler == nil {
+		return nil, ErrSlogHandlerMissing
+	}
+
+	_, spanHandled := handler.(*SlogHandler)
+
+	return &SlogLogger{
+		namespace:   namespace,
+		handler:     handler.WithAttrs([]slog.Attr{slog.String("namespace", namespace)}),
+		spanHandled: spanHandled,
+	}, nil
+}
+
+func (s *SlogLogger) Debug(msg string) Entry {
+	return s.newSlogEntry(LevelDebug, msg)
+}
+
+func (s *SlogLogger) Info(msg string) Entry {
+	return s.newSlogEntry(LevelInfo, msg)
+}
+
+func (s *SlogLogger) Warning(msg string) Entry {
+	return s.newSlogEntry(LevelWarning, msg)
+}
+
+func (s *SlogLogger) Error(msg string) Entry {
+	return s.newSlogEntry(LevelError, msg)
+}
+
+func (s *SlogLogger) With(fields ...Field) Logger {
+	if len(fields) == 0 {
+		return s
+	}
+
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+
+	return &SlogLogger{namespace: s.namespace, name: s.name, handler: s.handler.WithAttrs(attrs), spanHandled: s.spanHandled}
+}
+
+// Named joins names with "." like zap, the result is sent as the "logger" field
+func (s *SlogLogger) Named(name string) Logger {
+	if name == "" {
+		return s
+	}
+
+	if s.name != "" {
+		name = s.name + "." + name
+	}
+
+	return &SlogLogger{namespace: s.namespace, name: name, handler: s.handler, spanHandled: s.spanHandled}
+}
+
+func (s *SlogLogger) newSlogEntry(level int, msg string) Entry {
+	e := &slogEntry{
+		level:       toSlogLevel(level),
+		message:     msg,
+		handler:     s.handler,
+		ctx:         context.Background(),
+		spanHandled: s.spanHandled,
+	}
+
+	if s.name != "" {
+		e.attrs = append(e.attrs, slog.String("logger", s.name))
+	}
+
+	return e
+}
+
+// slogEntry is the Entry implementation of SlogLogger
+type slogEntry struct {
+	level       slog.Level
+	message     string
+	handler     slog.Handler
+	ctx         context.Context
+	span        *trace.Span
+	spanHandled bool
+	err         error
+	attrs       []slog.Attr
+	skip        int
+}
+
+func (e *slogEntry) For(ctx context.Context) Entry {
+	if ctx == nil {
+		return e
+	}
+
+	e.ctx = ctx
+	e.span = trace.FromContext(ctx)
+	if e.spanHandled {
+		return e
+	}
+
+	// other handlers have no trace format of their own
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		for _, f := range DefaultTraceFormat()(sc) {
+			e.WithField(f.Key, f.Value)
+		}
+	}
+
+	for _, f := range FieldsFromContext(ctx) {
+		e.WithField(f.Key, f.Value)
+	}
+
+	return e
+}
+
+func (e *slogEntry) WithField(key string, value interface{}) Entry {
+	e.attrs = append(e.attrs, slog.Any(key, value))
+	return e
+}
+
+func (e *slogEntry) WithError(err error) Entry {
+	if err == nil {
+		return e
+	}
+
+	e.err = err
+	e.attrs = append(e.attrs,
+		slog.String("error", err.Error()),
+		slog.String("errorType", fmt.Sprintf("%T", rootError(err))),
+	)
+	if causes := errorCauses(err); len(causes) > 1 {
+		e.attrs = append(e.attrs, slog.Any("errorCauses", causes[1:]))
+	}
+	e.attrs = append(e.attrs, slog.String("errorStack", errorStack(err, 1)))
+
+	return e
+}
+
+func (e *slogEntry) AddCallerSkip(skip int) Entry {
+	e.skip += skip
+	return e
+}
+
+func (e *slogEntry) Send() {
+	if e.span != nil {
+		if e.spanHandled {
+			// the handler's entry has the fields, not the error
+			if e.err != nil {
+				e.span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: e.err.Error()})
+			}
+		} else {
+			annotateSpan(e.span, e.message, e.traceAttributes(), e.err)
+		}
+	}
+
+	if !e.handler.Enabled(e.ctx, e.level) {
+		return
+	}
+
+	// skip runtime.Callers & Send, like slog.Logger does
+	var pcs [1]uintptr
+	runtime.Callers(2+e.skip, pcs[:])
+
+	r := slog.NewRecord(time.Now(), e.level, e.message, pcs[0])
+	r.AddAttrs(e.attrs...)
+	_ = e.handler.Handle(e.ctx, r)
+}
+
+// traceAttributes converts the attrs like StandardLogger converts fields
+func (e *slogEntry) traceAttributes() []trace.Attribute {
+	attributes := make([]trace.Attribute, 0, len(e.attrs))
+	for _, a := range e.attrs {
+		key, value, ok := slogField(a)
+		if !ok {
+			continue
+		}
+		logField, traceField := convertField(key, value)
+		attributes = append(attributes, traceAttribute(logField, traceField))
+	}
+	return attributes
+}
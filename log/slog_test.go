@@ -0,0 +1,202 @@
+//go:build go1.21
+// +build go1.21
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/trace"
+)
+
+func TestSlogHandler(t *testing.T) {
+	test := assert.New(t)
+
+	cfg, obs := ObservedConfig("test-1")
+	l, err := New("test-1", cfg)
+
+	ctx, span := trace.StartSpan(context.Background(), "test-context-1")
+	defer span.End()
+	ctx = ContextWithFields(ctx, F("RequestID", "req-1"))
+
+	if test.Nil(err) {
+		sl := l.Slog().With("component", "consumer")
+		sl.DebugContext(ctx, "dropped")
+		sl.WithGroup("req").With("method", "GET").InfoContext(ctx, "hello there",
+			"id", 1,
+			slog.Group("user", "name", "bob"),
+		)
+		sl.ErrorContext(ctx, "failed", slog.Group("", "inline", true))
+		sl.WithGroup("empty").WarnContext(ctx, "no fields")
+
+		if test.Equal(3, obs.Len()) {
+			entry := obs.All()[0]
+			test.Equal("hello there", entry.Message)
+			test.Equal(map[string]interface{}{
+				"component": "consumer",
+				"TraceID":   span.SpanContext().TraceID.String(),
+				"SpanID":    span.SpanContext().SpanID.String(),
+				"RequestID": "req-1",
+				"req": map[string]interface{}{
+					"method": "GET",
+					"id":     int64(1),
+					"user":   map[string]interface{}{"name": "bob"},
+				},
+			}, entry.ContextMap())
+
+			test.Equal("error", obs.All()[1].Level.String())
+			test.Equal(true, obs.All()[1].ContextMap()["inline"])
+
+			test.Equal("warn", obs.All()[2].Level.String())
+			test.NotContains(obs.All()[2].ContextMap(), "empty")
+		}
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	test := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	handler := slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+
+	_, err := NewSlogLogger("", handler)
+	test.Equal(ErrNamespaceMissing, err)
+	_, err = NewSlogLogger("test-1", nil)
+	test.Equal(ErrSlogHandlerMissing, err)
+
+	l, err := NewSlogLogger("test-1", handler)
+	if !test.Nil(err) {
+		return
+	}
+
+	ctx, span := trace.StartSpan(context.Background(), "test-context-1")
+	defer span.End()
+
+	var logger Logger = l
+	logger.Debug("dropped").Send()
+	logger.With(F("component", "consumer")).Named("worker").Warning("hello there").
+		For(ctx).
+		WithField("one", 1).
+		WithError(errors.New("boom")).
+		Send()
+
+	var entry map[string]interface{}
+	if test.Nil(json.Unmarshal(buf.Bytes(), &entry)) {
+		test.Equal("WARN", entry["level"])
+		test.Equal("hello there", entry["msg"])
+		test.Equal("test-1", entry["namespace"])
+		test.Equal("consumer", entry["component"])
+		test.Equal("worker", entry["logger"])
+		test.Equal(span.SpanContext().TraceID.String(), entry["TraceID"])
+		test.Equal(float64(1), entry["one"])
+		test.Equal("boom", entry["error"])
+	}
+}
+
+// spanExporter keeps the exported spans
+type spanExporter struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (e *spanExporter) ExportSpan(s *trace.SpanData) {
+	e.mu.Lock()
+	e.spans = append(e.spans, s)
+	e.mu.Unlock()
+}
+
+func TestSlogTrace(t *testing.T) {
+	test := assert.New(t)
+
+	exporter := &spanExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	cfg, obs := ObservedConfig("test-1")
+	l, err := New("test-1", cfg, TraceFormats(OTelTraceFormat()))
+	if !test.Nil(err) {
+		return
+	}
+
+	ctx, span := trace.StartSpan(context.Background(), "test-context-1", trace.WithSampler(trace.AlwaysSample()))
+
+	// the record time is kept
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	test.Nil(l.Slog().Handler().Handle(ctx, slog.NewRecord(at, slog.LevelInfo, "record", 0)))
+
+	// through a SlogHandler, For uses the logger's trace format & annotates the span once
+	sl, err := NewSlogLogger("test-1", NewSlogHandler(l))
+	if test.Nil(err) {
+		sl.Info("handler").For(ctx).WithField("one", 1).Send()
+	}
+
+	// other handlers are annotated by the entry
+	buf := &bytes.Buffer{}
+	jl, err := NewSlogLogger("test-1", slog.NewJSONHandler(buf, nil))
+	if test.Nil(err) {
+		jl.Warning("json").For(ctx).WithField("two", 2).WithError(errors.New("boom")).Send()
+	}
+
+	span.End()
+
+	if test.Equal(2, obs.Len()) {
+		test.True(at.Equal(obs.All()[0].Time))
+
+		fields := obs.All()[1].ContextMap()
+		test.Equal(span.SpanContext().TraceID.String(), fields["trace_id"])
+		test.NotContains(fields, "TraceID")
+	}
+
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	if test.Len(exporter.spans, 1) {
+		var messages []string
+		for _, a := range exporter.spans[0].Annotations {
+			messages = append(messages, a.Message)
+		}
+		test.Equal([]string{"record", "handler", "json"}, messages)
+		test.Equal(int64(2), exporter.spans[0].Annotations[2].Attributes["two"])
+		test.Equal("boom", exporter.spans[0].Status.Message)
+	}
+}
+
+func TestSlogCaller(t *testing.T) {
+	test := assert.New(t)
+
+	cfg, obs := ObservedConfig("test-1")
+	l, err := New("test-1", cfg)
+	if !test.Nil(err) {
+		return
+	}
+
+	_, _, line, _ := runtime.Caller(0)
+	l.Slog().Info("handler")
+	if test.Equal(1, obs.Len()) {
+		caller := obs.All()[0].Caller
+		test.Equal("slog_test.go", filepath.Base(caller.File))
+		test.Equal(line+1, caller.Line)
+		test.Equal("github.com/sqrt-7/microwave/log.TestSlogCaller", caller.Function)
+	}
+
+	buf := &bytes.Buffer{}
+	sl, _ := NewSlogLogger("test-1", slog.NewJSONHandler(buf, &slog.HandlerOptions{AddSource: true}))
+	_, _, line, _ = runtime.Caller(0)
+	sl.Info("logger").Send()
+
+	out := map[string]interface{}{}
+	if test.Nil(json.Unmarshal(buf.Bytes(), &out)) {
+		source, _ := out["source"].(map[string]interface{})
+		test.Equal("github.com/sqrt-7/microwave/log.TestSlogCaller", source["function"])
+		test.Equal(float64(line+1), source["line"])
+	}
+}
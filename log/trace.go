@@ -0,0 +1,174 @@
+package log
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"go.opencensus.io/trace"
+)
+
+// W3C trace context header (https://www.w3.org/TR/trace-context/)
+const TraceParentHeader = "traceparent"
+
+// TraceFormat returns the trace correlation fields added by For
+type TraceFormat func(sc trace.SpanContext) []Field
+
+// DefaultTraceFormat adds TraceID & SpanID
+func DefaultTraceFormat() TraceFormat {
+	return func(sc trace.SpanContext) []Field {
+		return []Field{
+			F("TraceID", sc.TraceID.String()),
+			F("SpanID", sc.SpanID.String()),
+		}
+	}
+}
+
+// GCPTraceFormat adds the fields Google Cloud Logging links to Cloud Trace
+func GCPTraceFormat(projectID string) TraceFormat {
+	return func(sc trace.SpanContext) []Field {
+		return []Field{
+			F("logging.googleapis.com/trace", fmt.Sprintf("projects/%s/traces/%s", projectID, sc.TraceID.String())),
+			F("logging.googleapis.com/spanId", sc.SpanID.String()),
+			F("logging.googleapis.com/trace_sampled", sc.IsSampled()),
+		}
+	}
+}
+
+// OTelTraceFormat adds trace_id, span_id & trace_flags as named by the OpenTelemetry
+// log data model (used by Grafana Loki derived fields & Tempo)
+func OTelTraceFormat() TraceFormat {
+	return func(sc trace.SpanContext) []Field {
+		return []Field{
+			F("trace_id", sc.TraceID.String()),
+			F("span_id", sc.SpanID.String()),
+			F("trace_flags", fmt.Sprintf("%02x", uint8(sc.TraceOptions))),
+		}
+	}
+}
+
+// TraceFormats replaces the trace correlation fields (DefaultTraceFormat),
+// several formats can be combined to serve more than one log backend
+func TraceFormats(formats ...TraceFormat) Option {
+	return optionFn(func(input *StandardLogger) {
+		input.correlation = input.correlation.copy()
+		input.correlation.formats = formats
+	})
+}
+
+// TraceContext adds a source of span contexts, used by For when ctx has no OpenCensus span
+// (e.g. converting the OpenTelemetry span context, IDs have the same size)
+func TraceContext(fn func(context.Context) (trace.SpanContext, bool)) Option {
+	return optionFn(func(input *StandardLogger) {
+		input.correlation = input.correlation.copy()
+		input.correlation.source = fn
+	})
+}
+
+// TraceFields returns the trace correlation fields For adds for the span context
+func (s *StandardLogger) TraceFields(sc trace.SpanContext) []Field {
+	return s.correlation.fields(sc)
+}
+
+// traceCorrelation is the config set by TraceFormats & TraceContext
+type traceCorrelation struct {
+	formats []TraceFormat
+	source  func(context.Context) (trace.SpanContext, bool)
+}
+
+func (c *traceCorrelation) copy() *traceCorrelation {
+	if c == nil {
+		return &traceCorrelation{formats: []TraceFormat{DefaultTraceFormat()}}
+	}
+
+	cp := *c
+	return &cp
+}
+
+// spanContext looks up the OpenCensus span, the TraceContext source, then ContextWithSpanContext
+func (c *traceCorrelation) spanContext(ctx context.Context) (trace.SpanContext, bool) {
+	if ctx == nil {
+		return trace.SpanContext{}, false
+	}
+
+	if span := trace.FromContext(ctx); span != nil {
+		return span.SpanContext(), true
+	}
+
+	if c != nil && c.source != nil {
+		if sc, ok := c.source(ctx); ok && sc.TraceID != (trace.TraceID{}) {
+			return sc, true
+		}
+	}
+
+	return SpanContextFromContext(ctx)
+}
+
+func (c *traceCorrelation) fields(sc trace.SpanContext) []Field {
+	if c == nil {
+		return DefaultTraceFormat()(sc)
+	}
+
+	var fields []Field
+	for _, format := range c.formats {
+		fields = append(fields, format(sc)...)
+	}
+	return fields
+}
+
+// ParseTraceParent parses a W3C traceparent header value
+func ParseTraceParent(value string) (trace.SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return trace.SpanContext{}, false
+	}
+
+	version, ok := decodeHex(parts[0], 1)
+	// version ff is invalid, version 00 has exactly 4 parts (later versions may add more)
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return trace.SpanContext{}, false
+	}
+
+	var sc trace.SpanContext
+
+	traceID, ok := decodeHex(parts[1], len(sc.TraceID))
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	copy(sc.TraceID[:], traceID)
+
+	spanID, ok := decodeHex(parts[2], len(sc.SpanID))
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	copy(sc.SpanID[:], spanID)
+
+	flags, ok := decodeHex(parts[3], 1)
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	sc.TraceOptions = trace.TraceOptions(flags[0])
+
+	// all-zero IDs are invalid
+	if sc.TraceID == (trace.TraceID{}) || sc.SpanID == (trace.SpanID{}) {
+		return trace.SpanContext{}, false
+	}
+
+	return sc, true
+}
+
+// FormatTraceParent returns the W3C traceparent header value of the span context
+func FormatTraceParent(sc trace.SpanContext) string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID.String(), sc.SpanID.String(), uint8(sc.TraceOptions))
+}
+
+// decodeHex decodes lowercase hex of exactly size bytes
+func decodeHex(s string, size int) ([]byte, bool) {
+	if len(s) != size*2 || strings.ToLower(s) != s {
+		return nil, false
+	}
+
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
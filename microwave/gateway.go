@@ -0,0 +1,675 @@
+package microwave
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sqrt-7/microwave/log"
+	"go.opencensus.io/trace"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	// prefix of the response headers carrying the gRPC response header metadata
+	gatewayMetadataHeaderPrefix = "Grpc-Metadata-"
+
+	// max request body read by the Gateway
+	gatewayMaxBodySize = 4 << 20
+)
+
+var (
+	ErrGatewayServerMissing = errors.New("gateway grpc server missing")
+	ErrGatewayConnMissing   = errors.New("gateway grpc client connection missing")
+
+	errGatewayBodyTooLarge = errors.New("request body too large")
+)
+
+// GatewayConfig configures NewGateway
+type GatewayConfig struct {
+	// request headers sent as gRPC metadata (default: Authorization & the log context headers)
+	ForwardHeaders []string
+}
+
+// Gateway is an http.Handler transcoding HTTP/JSON requests to the unary gRPC methods
+// annotated with google.api.http, like grpc-gateway. Mounted behind DefaultHTTPMiddlewares the
+// request ID & span are forwarded, so gRPC entries share the HTTP request's log context
+type Gateway struct {
+	conn    grpc.ClientConnInterface
+	routes  []*gatewayRoute
+	forward []string
+}
+
+type gatewayRoute struct {
+	method       string
+	template     *pathTemplate
+	body         string
+	responseBody string
+	fullMethod   string
+	input        protoreflect.MessageType
+	output       protoreflect.MessageType
+}
+
+// NewGateway routes the google.api.http bindings of the services registered on server,
+// calls are sent through conn (e.g. a connection to the server's own port)
+func NewGateway(server *grpc.Server, conn grpc.ClientConnInterface, cfg GatewayConfig) (*Gateway, error) {
+	if server == nil {
+		return nil, ErrGatewayServerMissing
+	}
+
+	if conn == nil {
+		return nil, ErrGatewayConnMissing
+	}
+
+	g := &Gateway{
+		conn:    conn,
+		forward: cfg.ForwardHeaders,
+	}
+
+	if g.forward == nil {
+		g.forward = []string{"authorization"}
+		for _, h := range contextFieldHeaders {
+			g.forward = append(g.forward, h.header)
+		}
+	}
+
+	services := make([]string, 0)
+	for name := range server.GetServiceInfo() {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+
+	for _, name := range services {
+		desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			// services without a registered descriptor have no annotations
+			continue
+		}
+
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			continue
+		}
+
+		if err := g.addService(sd); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+func (g *Gateway) addService(sd protoreflect.ServiceDescriptor) error {
+	for i := 0; i < sd.Methods().Len(); i++ {
+		md := sd.Methods().Get(i)
+		if md.IsStreamingClient() || md.IsStreamingServer() {
+			continue
+		}
+
+		rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+
+		for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+			route, err := newGatewayRoute(md, r)
+			if err != nil {
+				return errors.Wrapf(err, "gateway %s", md.FullName())
+			}
+			g.routes = append(g.routes, route)
+		}
+	}
+
+	return nil
+}
+
+func newGatewayRoute(md protoreflect.MethodDescriptor, rule *annotations.HttpRule) (*gatewayRoute, error) {
+	var method, path string
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		method, path = http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		method, path = http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		method, path = http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		method, path = http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		method, path = http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		method, path = strings.ToUpper(p.Custom.GetKind()), p.Custom.GetPath()
+	default:
+		return nil, errors.New("http rule without pattern")
+	}
+
+	template, err := parsePathTemplate(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gatewayRoute{
+		method:       method,
+		template:     template,
+		body:         rule.GetBody(),
+		responseBody: rule.GetResponseBody(),
+		fullMethod:   fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name()),
+		input:        messageType(md.Input()),
+		output:       messageType(md.Output()),
+	}, nil
+}
+
+// messageType prefers the generated type, dynamic messages are used for descriptors built at runtime
+func messageType(md protoreflect.MessageDescriptor) protoreflect.MessageType {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName()); err == nil {
+		return mt
+	}
+	return dynamicpb.NewMessageType(md)
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		route      *gatewayRoute
+		params     map[string]string
+		bestStatic = -1
+		allowed    = false
+	)
+
+	for _, rt := range g.routes {
+		p, static, ok := rt.template.match(r.URL.EscapedPath())
+		if !ok {
+			continue
+		}
+
+		if rt.method != r.Method {
+			allowed = true
+			continue
+		}
+
+		if static > bestStatic {
+			route, params, bestStatic = rt, p, static
+		}
+	}
+
+	if route == nil {
+		if allowed {
+			writeGatewayError(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		writeGatewayError(w, http.StatusNotFound, status.New(codes.NotFound, http.StatusText(http.StatusNotFound)))
+		return
+	}
+
+	req, err := route.bind(r, params)
+	if err == errGatewayBodyTooLarge {
+		writeGatewayError(w, http.StatusRequestEntityTooLarge, status.New(codes.ResourceExhausted, err.Error()))
+		return
+	}
+	if err != nil {
+		st := status.New(codes.InvalidArgument, err.Error())
+		writeGatewayError(w, HTTPStatusFromCode(st.Code()), st)
+		return
+	}
+
+	resp := route.output.New().Interface()
+	var header metadata.MD
+	if err := g.conn.Invoke(g.outgoingContext(r), route.fullMethod, req, resp, grpc.Header(&header)); err != nil {
+		st := status.Convert(err)
+		writeGatewayError(w, HTTPStatusFromCode(st.Code()), st)
+		return
+	}
+
+	body, err := route.marshal(resp)
+	if err != nil {
+		st := status.New(codes.Internal, http.StatusText(http.StatusInternalServerError))
+		writeGatewayError(w, http.StatusInternalServerError, st)
+		return
+	}
+
+	for k, vs := range header {
+		if k == "content-type" {
+			continue
+		}
+		for _, v := range vs {
+			w.Header().Add(gatewayMetadataHeaderPrefix+k, v)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// outgoingContext forwards the selected headers & the current span as traceparent
+func (g *Gateway) outgoingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for _, h := range g.forward {
+		if vs := r.Header.Values(h); len(vs) > 0 {
+			md.Append(strings.ToLower(h), vs...)
+		}
+	}
+
+	setTraceParent(md, r)
+
+	return metadata.NewOutgoingContext(r.Context(), md)
+}
+
+// setTraceParent forwards the current span (or the caller's traceparent) as traceparent metadata,
+// the gRPC server continues the trace (see traceParentHandler)
+func setTraceParent(md metadata.MD, r *http.Request) {
+	if span := trace.FromContext(r.Context()); span != nil {
+		md.Set(log.TraceParentHeader, log.FormatTraceParent(span.SpanContext()))
+	} else if v := r.Header.Get(log.TraceParentHeader); v != "" {
+		md.Set(log.TraceParentHeader, v)
+	}
+}
+
+// bind fills the request message from the body, the path variables & the query parameters
+func (rt *gatewayRoute) bind(r *http.Request, params map[string]string) (proto.Message, error) {
+	req := rt.input.New()
+
+	if rt.body != "" {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, gatewayMaxBodySize+1))
+		if err != nil {
+			return nil, errors.Wrap(err, "read body")
+		}
+		if len(body) > gatewayMaxBodySize {
+			return nil, errGatewayBodyTooLarge
+		}
+
+		if len(body) > 0 {
+			if err := rt.unmarshalBody(req, body); err != nil {
+				return nil, errors.Wrap(err, "invalid body")
+			}
+		}
+	}
+
+	for field, value := range params {
+		if err := setField(req, field, []string{value}); err != nil {
+			return nil, err
+		}
+	}
+
+	// with body "*" every field comes from the body
+	if rt.body == "*" {
+		return req.Interface(), nil
+	}
+
+	for key, values := range r.URL.Query() {
+		if _, ok := params[key]; ok || key == rt.body {
+			continue
+		}
+		if err := setField(req, key, values); err != nil {
+			return nil, err
+		}
+	}
+
+	return req.Interface(), nil
+}
+
+// unmarshalBody decodes the body into the whole request or into the body field only
+func (rt *gatewayRoute) unmarshalBody(req protoreflect.Message, body []byte) error {
+	opts := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if rt.body == "*" {
+		return opts.Unmarshal(body, req.Interface())
+	}
+
+	fd := findField(req.Descriptor(), rt.body)
+	if fd == nil {
+		return errors.Errorf("body field %q not found", rt.body)
+	}
+
+	if fd.Kind() == protoreflect.MessageKind && !fd.IsList() && !fd.IsMap() {
+		return opts.Unmarshal(body, req.Mutable(fd).Message().Interface())
+	}
+
+	// scalars, lists & maps are decoded as the single field of a fresh message,
+	// json.Marshal rejects a body which isn't exactly one JSON value
+	wrapped, err := json.Marshal(map[string]json.RawMessage{fd.JSONName(): body})
+	if err != nil {
+		return err
+	}
+
+	tmp := req.New()
+	if err := opts.Unmarshal(wrapped, tmp.Interface()); err != nil {
+		return err
+	}
+	if tmp.Has(fd) {
+		req.Set(fd, tmp.Get(fd))
+	}
+
+	return nil
+}
+
+func (rt *gatewayRoute) marshal(resp proto.Message) ([]byte, error) {
+	if rt.responseBody == "" {
+		return protojson.Marshal(resp)
+	}
+
+	fd := findField(resp.ProtoReflect().Descriptor(), rt.responseBody)
+	if fd == nil {
+		return nil, errors.Errorf("response body field %q not found", rt.responseBody)
+	}
+
+	b, err := (protojson.MarshalOptions{EmitUnpopulated: true}).Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields[fd.JSONName()], nil
+}
+
+// setField sets the (dotted) field path from query or path values, lists get every value
+func setField(msg protoreflect.Message, path string, values []string) error {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		fd := findField(msg.Descriptor(), part)
+		if fd == nil || fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return errors.Errorf("field %q not found", path)
+		}
+		msg = msg.Mutable(fd).Message()
+	}
+
+	fd := findField(msg.Descriptor(), parts[len(parts)-1])
+	if fd == nil || fd.IsMap() {
+		return errors.Errorf("field %q not found", path)
+	}
+
+	if fd.IsList() {
+		list := msg.Mutable(fd).List()
+		for _, s := range values {
+			v, err := parseFieldValue(msg, fd, s)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+		return nil
+	}
+
+	v, err := parseFieldValue(msg, fd, values[len(values)-1])
+	if err != nil {
+		return err
+	}
+	msg.Set(fd, v)
+
+	return nil
+}
+
+func parseFieldValue(msg protoreflect.Message, fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	var (
+		v   protoreflect.Value
+		err error
+	)
+
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		v = protoreflect.ValueOfString(s)
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v = protoreflect.ValueOfBool(b)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 32)
+		v = protoreflect.ValueOfInt32(int32(n))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 64)
+		v = protoreflect.ValueOfInt64(n)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 32)
+		v = protoreflect.ValueOfUint32(uint32(n))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 64)
+		v = protoreflect.ValueOfUint64(n)
+	case protoreflect.FloatKind:
+		var f float64
+		f, err = strconv.ParseFloat(s, 32)
+		v = protoreflect.ValueOfFloat32(float32(f))
+	case protoreflect.DoubleKind:
+		var f float64
+		f, err = strconv.ParseFloat(s, 64)
+		v = protoreflect.ValueOfFloat64(f)
+	case protoreflect.BytesKind:
+		var b []byte
+		b, err = base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(s)
+		}
+		v = protoreflect.ValueOfBytes(b)
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			v = protoreflect.ValueOfEnum(ev.Number())
+			break
+		}
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 32)
+		v = protoreflect.ValueOfEnum(protoreflect.EnumNumber(n))
+	case protoreflect.MessageKind:
+		// well-known types (Timestamp, Duration, wrappers...) parse from their JSON string form
+		m := msg.NewField(fd).Message()
+		b, _ := json.Marshal(s)
+		err = protojson.Unmarshal(b, m.Interface())
+		v = protoreflect.ValueOfMessage(m)
+	default:
+		err = errors.New("unsupported type")
+	}
+
+	if err != nil {
+		return protoreflect.Value{}, errors.Errorf("invalid value %q for field %q", s, fd.Name())
+	}
+
+	return v, nil
+}
+
+// findField looks a field up by proto or JSON name
+func findField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := md.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return md.Fields().ByJSONName(name)
+}
+
+// gatewayError is the JSON body of Gateway errors (code is the gRPC code, like grpc-gateway)
+type gatewayError struct {
+	Code    codes.Code `json:"code"`
+	Message string     `json:"message"`
+}
+
+func writeGatewayError(w http.ResponseWriter, httpStatus int, st *status.Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(httpStatus)
+	_ = json.NewEncoder(w).Encode(gatewayError{Code: st.Code(), Message: st.Message()})
+}
+
+// HTTPStatusFromCode maps gRPC codes to HTTP status codes like grpc-gateway
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// pathTemplate is a google.api.http path template, e.g. "/v1/{name=operations/**}:cancel"
+type pathTemplate struct {
+	segments []string // literal, "*" or "**"
+	verb     string
+	vars     []templateVar
+}
+
+// templateVar binds the path segments [start, end) to a field, end -1 is the end of the path
+type templateVar struct {
+	field      string
+	start, end int
+}
+
+func parsePathTemplate(tmpl string) (*pathTemplate, error) {
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil, errors.Errorf("path template %q must start with /", tmpl)
+	}
+
+	t := &pathTemplate{}
+	path := tmpl[1:]
+
+	if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") && i > strings.LastIndex(path, "}") {
+		path, t.verb = path[:i], path[i+1:]
+	}
+
+	for _, token := range splitTemplate(path) {
+		if !strings.HasPrefix(token, "{") {
+			t.segments = append(t.segments, token)
+			continue
+		}
+
+		if !strings.HasSuffix(token, "}") {
+			return nil, errors.Errorf("path template %q has an unclosed variable", tmpl)
+		}
+
+		field, pattern := token[1:len(token)-1], "*"
+		if i := strings.Index(field, "="); i >= 0 {
+			field, pattern = field[:i], field[i+1:]
+		}
+
+		v := templateVar{field: field, start: len(t.segments)}
+		t.segments = append(t.segments, strings.Split(pattern, "/")...)
+		v.end = len(t.segments)
+		if t.segments[len(t.segments)-1] == "**" {
+			v.end = -1
+		}
+		t.vars = append(t.vars, v)
+	}
+
+	for i, s := range t.segments {
+		if s == "" || (s == "**" && i != len(t.segments)-1) {
+			return nil, errors.Errorf("invalid path template %q", tmpl)
+		}
+	}
+
+	return t, nil
+}
+
+// splitTemplate splits on the slashes outside of variables
+func splitTemplate(path string) []string {
+	var (
+		tokens []string
+		depth  int
+		start  int
+	)
+
+	for i, c := range path {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				tokens = append(tokens, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(tokens, path[start:])
+}
+
+// match returns the unescaped variables & the number of literal segments matched
+func (t *pathTemplate) match(escapedPath string) (map[string]string, int, bool) {
+	if !strings.HasPrefix(escapedPath, "/") {
+		return nil, 0, false
+	}
+	path := escapedPath[1:]
+
+	if t.verb != "" {
+		if !strings.HasSuffix(path, ":"+t.verb) {
+			return nil, 0, false
+		}
+		path = strings.TrimSuffix(path, ":"+t.verb)
+	}
+
+	segments := strings.Split(path, "/")
+	static := 0
+	for i, s := range t.segments {
+		if i >= len(segments) || segments[i] == "" {
+			return nil, 0, false
+		}
+		// "**" matches the remaining (one or more) segments
+		if s == "**" {
+			break
+		}
+		if s != "*" {
+			if s != segments[i] {
+				return nil, 0, false
+			}
+			static++
+		}
+	}
+
+	if last := len(t.segments) - 1; len(segments) != len(t.segments) && (last < 0 || t.segments[last] != "**") {
+		return nil, 0, false
+	}
+
+	params := make(map[string]string, len(t.vars))
+	for _, v := range t.vars {
+		end := v.end
+		if end < 0 {
+			end = len(segments)
+		}
+
+		value, err := url.PathUnescape(strings.Join(segments[v.start:end], "/"))
+		if err != nil {
+			return nil, 0, false
+		}
+		params[v.field] = value
+	}
+
+	return params, static, true
+}
@@ -0,0 +1,189 @@
+package microwave_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sqrt-7/microwave/log"
+	"github.com/sqrt-7/microwave/log/logtest"
+	"github.com/sqrt-7/microwave/microwave"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/example/library/v1"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// operationsServer is a fake google.longrunning.Operations service (it has google.api.http annotations)
+type operationsServer struct {
+	longrunning.UnimplementedOperationsServer
+}
+
+func (s *operationsServer) GetOperation(ctx context.Context, req *longrunning.GetOperationRequest) (*longrunning.Operation, error) {
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-served-by", "operations"))
+	log.FromContext(ctx).Info("GET_OPERATION").For(ctx).Send()
+	return &longrunning.Operation{Name: req.Name, Done: true}, nil
+}
+
+func (s *operationsServer) ListOperations(ctx context.Context, req *longrunning.ListOperationsRequest) (*longrunning.ListOperationsResponse, error) {
+	return &longrunning.ListOperationsResponse{
+		Operations:    []*longrunning.Operation{{Name: req.Name + "/" + req.Filter}},
+		NextPageToken: strings.Repeat("x", int(req.PageSize)),
+	}, nil
+}
+
+func (s *operationsServer) DeleteOperation(ctx context.Context, req *longrunning.DeleteOperationRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.NotFound, "operation %s not found", req.Name)
+}
+
+func (s *operationsServer) CancelOperation(ctx context.Context, req *longrunning.CancelOperationRequest) (*emptypb.Empty, error) {
+	if req.Name != "operations/op-1" {
+		return nil, status.Error(codes.InvalidArgument, "unexpected name "+req.Name)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// libraryServer is a fake google.example.library.v1.LibraryService (CreateBook binds the "book" body field)
+type libraryServer struct {
+	library.UnimplementedLibraryServiceServer
+}
+
+func (s *libraryServer) CreateBook(ctx context.Context, req *library.CreateBookRequest) (*library.Book, error) {
+	return &library.Book{Name: req.Name + "/books/1", Title: req.Book.GetTitle()}, nil
+}
+
+func TestGateway(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := microwave.NewGRPCServer(microwave.DefaultGRPCInterceptors(rec))
+	longrunning.RegisterOperationsServer(srv, &operationsServer{})
+	library.RegisterLibraryServiceServer(srv, &libraryServer{})
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if !test.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	_, err = microwave.NewGateway(nil, conn, microwave.GatewayConfig{})
+	test.Equal(microwave.ErrGatewayServerMissing, err)
+	_, err = microwave.NewGateway(srv, nil, microwave.GatewayConfig{})
+	test.Equal(microwave.ErrGatewayConnMissing, err)
+
+	gw, err := microwave.NewGateway(srv, conn, microwave.GatewayConfig{})
+	if !test.Nil(err) {
+		return
+	}
+	handler := microwave.NewHTTPServer(gw, microwave.DefaultHTTPMiddlewares(rec)).Handler
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		resp   string
+	}{
+		{
+			name:   "path variable with **",
+			method: http.MethodGet, path: "/v1/operations/op-1/sub%2Fpart",
+			status: http.StatusOK, resp: `{"name":"operations/op-1/sub/part","done":true}`,
+		},
+		{
+			name:   "query parameters",
+			method: http.MethodGet, path: "/v1/operations?filter=done&page_size=3",
+			status: http.StatusOK, resp: `{"operations":[{"name":"operations/done"}],"nextPageToken":"xxx"}`,
+		},
+		{
+			name:   "query parameters by JSON name",
+			method: http.MethodGet, path: "/v1/operations?pageSize=2",
+			status: http.StatusOK, resp: `{"operations":[{"name":"operations/"}],"nextPageToken":"xx"}`,
+		},
+		{
+			name:   "verb & body",
+			method: http.MethodPost, path: "/v1/operations/op-1:cancel", body: `{"name":"ignored"}`,
+			status: http.StatusOK, resp: `{}`,
+		},
+		{
+			name:   "body field",
+			method: http.MethodPost, path: "/v1/shelves/s1/books", body: `{"title":"Dune"}`,
+			status: http.StatusOK, resp: `{"name":"shelves/s1/books/1","title":"Dune"}`,
+		},
+		{
+			name:   "body field with sibling fields",
+			method: http.MethodPost, path: "/v1/shelves/s1/books", body: `{"title":"Dune"},"name":"shelves/s2"`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "body too large",
+			method: http.MethodPost, path: "/v1/operations/op-1:cancel", body: `{"name":"` + strings.Repeat("x", 4<<20) + `"}`,
+			status: http.StatusRequestEntityTooLarge, resp: `{"code":8,"message":"request body too large"}`,
+		},
+		{
+			name:   "gRPC status",
+			method: http.MethodDelete, path: "/v1/operations/op-2",
+			status: http.StatusNotFound, resp: `{"code":5,"message":"operation operations/op-2 not found"}`,
+		},
+		{
+			name:   "invalid query parameter",
+			method: http.MethodGet, path: "/v1/operations?page_size=many",
+			status: http.StatusBadRequest, resp: `{"code":3,"message":"invalid value \"many\" for field \"page_size\""}`,
+		},
+		{
+			name:   "unknown query parameter",
+			method: http.MethodGet, path: "/v1/operations?missing=1",
+			status: http.StatusBadRequest, resp: `{"code":3,"message":"field \"missing\" not found"}`,
+		},
+		{
+			name:   "method not allowed",
+			method: http.MethodPut, path: "/v1/operations/op-1",
+			status: http.StatusMethodNotAllowed, resp: `{"code":12,"message":"Method Not Allowed"}`,
+		},
+		{
+			name:   "not found",
+			method: http.MethodGet, path: "/v2/operations",
+			status: http.StatusNotFound, resp: `{"code":5,"message":"Not Found"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+		test.Equal(tt.status, resp.Code, tt.name)
+		test.Equal("application/json", resp.Header().Get("Content-Type"), tt.name)
+		if tt.resp != "" {
+			test.JSONEq(tt.resp, resp.Body.String(), tt.name)
+		}
+	}
+
+	// headers & trace are forwarded, both sides log with the same request ID & trace
+	req := httptest.NewRequest(http.MethodGet, "/v1/operations/op-1", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	req.Header.Set(log.TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	test.Equal("operations", resp.Header().Get("Grpc-Metadata-X-Served-By"))
+
+	traceID := log.F("TraceID", "4bf92f3577b34da6a3ce929d0e0e4736")
+	rec.HasEntry(t, log.LevelInfo, "HTTP_IN", log.F("RequestID", "req-1"), traceID)
+	rec.HasEntry(t, log.LevelInfo, "GRPC_IN", log.F("RequestID", "req-1"), traceID, log.F("method", "/google.longrunning.Operations/GetOperation"))
+	rec.HasEntry(t, log.LevelInfo, "GET_OPERATION", log.F("RequestID", "req-1"), traceID)
+}
@@ -0,0 +1,127 @@
+package microwave
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrGRPCDebugServerMissing = errors.New("grpc debug services enabled without grpc server or admin port")
+	ErrGRPCDebugAuthAdminPort = errors.New("grpc debug auth set without admin port")
+)
+
+// grpcDebug serves the reflection & channelz services, on the admin port
+// or registered on the gRPC servers of the wrappers
+type grpcDebug struct {
+	reflection   bool
+	channelz     bool
+	adminPort    string
+	adminOptions []grpc.ServerOption
+	auth         func(ctx context.Context) error
+}
+
+func (d *grpcDebug) enabled() bool {
+	return d != nil && (d.reflection || d.channelz)
+}
+
+// wrapper registers the debug services & returns the admin port wrapper (nil without admin port),
+// it's called before the servers start as gRPC servers don't accept registrations once serving
+func (d *grpcDebug) wrapper(mw *Microwave) (ServerWrapper, error) {
+	if d.adminPort != "" {
+		unary, stream := DefaultGRPCInterceptors(mw.logger)
+		admin := NewGRPCServer(append(unary, d.unaryAuth), append(stream, d.streamAuth), d.adminOptions...)
+		d.register(admin)
+		return GRPCWrapper{Port: d.adminPort, Server: admin}, nil
+	}
+
+	// the services of the wrappers' servers run their interceptors only
+	if d.auth != nil {
+		return nil, ErrGRPCDebugAuthAdminPort
+	}
+
+	servers := grpcServers(mw.servers)
+	if len(servers) == 0 {
+		return nil, ErrGRPCDebugServerMissing
+	}
+
+	for _, srv := range servers {
+		d.register(srv)
+	}
+
+	return nil, nil
+}
+
+// register adds the enabled services to srv, unless already registered (e.g. by a previous Start)
+func (d *grpcDebug) register(srv *grpc.Server) {
+	registered := srv.GetServiceInfo()
+
+	if _, ok := registered["grpc.reflection.v1alpha.ServerReflection"]; d.reflection && !ok {
+		reflection.Register(srv)
+	}
+	if _, ok := registered["grpc.channelz.v1.Channelz"]; d.channelz && !ok {
+		channelz.RegisterChannelzServiceToServer(srv)
+	}
+}
+
+// authorize runs the auth check, errors without a gRPC status are reported as PermissionDenied
+func (d *grpcDebug) authorize(ctx context.Context) error {
+	if d.auth == nil {
+		return nil
+	}
+
+	err := d.auth(ctx)
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.PermissionDenied, err.Error())
+}
+
+func (d *grpcDebug) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := d.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (d *grpcDebug) streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := d.authorize(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// grpcServers returns the gRPC servers of the built-in wrappers
+func grpcServers(wrappers []ServerWrapper) []*grpc.Server {
+	var servers []*grpc.Server
+	seen := make(map[*grpc.Server]bool)
+
+	for _, w := range wrappers {
+		var srv *grpc.Server
+		switch wrapper := w.(type) {
+		case GRPCWrapper:
+			srv = wrapper.Server
+		case *GRPCWrapper:
+			srv = wrapper.Server
+		case MuxWrapper:
+			srv = wrapper.GRPCServer
+		case *MuxWrapper:
+			srv = wrapper.GRPCServer
+		}
+
+		if srv != nil && !seen[srv] {
+			seen[srv] = true
+			servers = append(servers, srv)
+		}
+	}
+
+	return servers
+}
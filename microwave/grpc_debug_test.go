@@ -0,0 +1,215 @@
+package microwave_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/sqrt-7/microwave/log"
+	"github.com/sqrt-7/microwave/log/logtest"
+	"github.com/sqrt-7/microwave/microwave"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// listServices lists the services with the reflection service
+func listServices(ctx context.Context, conn *grpc.ClientConn) ([]string, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_ListServices{}}); err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	_ = stream.CloseSend()
+
+	var names []string
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		names = append(names, svc.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// startDebug starts mw with a test service on a new port, stopped with SIGTERM once done
+func startDebug(t *testing.T, mw *microwave.Microwave) (*grpc.ClientConn, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := microwave.NewGRPCServer(microwave.DefaultGRPCInterceptors(mw.Logger()))
+	testpb.RegisterTestServiceServer(srv, &testServer{})
+	if err := mw.AddServer(&microwave.GRPCWrapper{Listener: lis, Server: srv}); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		mw.Start()
+	}()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, func() {
+		_ = conn.Close()
+		_ = syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+		<-stopped
+	}
+}
+
+func TestGRPCDebug(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+	mw, err := microwave.New("my-service",
+		microwave.CustomLogger(rec),
+		microwave.GRPCReflection(),
+		microwave.GRPCChannelz(),
+	)
+	if !test.Nil(err) {
+		return
+	}
+
+	conn, stop := startDebug(t, mw)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the application service is served as usual
+	_, err = testpb.NewTestServiceClient(conn).UnaryCall(ctx, &testpb.SimpleRequest{}, grpc.WaitForReady(true))
+	test.Nil(err)
+
+	services, err := listServices(ctx, conn)
+	if test.Nil(err) {
+		test.Equal([]string{"grpc.channelz.v1.Channelz", "grpc.reflection.v1alpha.ServerReflection", "grpc.testing.TestService"}, services)
+	}
+
+	servers, err := channelzpb.NewChannelzClient(conn).GetServers(ctx, &channelzpb.GetServersRequest{})
+	if test.Nil(err) {
+		test.NotEmpty(servers.Server)
+	}
+
+	// the services run the interceptors of the server
+	rec.HasEntry(t, log.LevelInfo, "GRPC_IN", log.F("method", "/grpc.channelz.v1.Channelz/GetServers"))
+}
+
+func TestGRPCDebugErrors(t *testing.T) {
+	test := assert.New(t)
+
+	for _, tt := range []struct {
+		name    string
+		options []microwave.Option
+		server  bool
+		err     error
+	}{
+		{
+			name:    "no grpc server",
+			options: []microwave.Option{microwave.GRPCReflection()},
+			err:     microwave.ErrGRPCDebugServerMissing,
+		},
+		{
+			name:    "auth without admin port",
+			options: []microwave.Option{microwave.GRPCChannelz(), microwave.GRPCDebugAuth(func(ctx context.Context) error { return nil })},
+			server:  true,
+			err:     microwave.ErrGRPCDebugAuthAdminPort,
+		},
+	} {
+		rec := logtest.New("my-service")
+		mw, err := microwave.New("my-service", append(tt.options, microwave.CustomLogger(rec))...)
+		if !test.Nil(err, tt.name) {
+			continue
+		}
+
+		if tt.server {
+			srv := microwave.NewGRPCServer(microwave.DefaultGRPCInterceptors(rec))
+			test.Nil(mw.AddServer(&microwave.GRPCWrapper{Port: "127.0.0.1:0", Server: srv}), tt.name)
+		}
+
+		// Start returns on the error, without starting the servers
+		mw.Start()
+		rec.HasEntry(t, log.LevelError, "GRPC_DEBUG_ERROR", log.F("error", tt.err.Error()))
+		test.Equal(0, rec.Count(log.LevelInfo, "GRPC_SERVER_STARTED"), tt.name)
+	}
+}
+
+func TestGRPCDebugAdminPort(t *testing.T) {
+	test := assert.New(t)
+
+	_, err := microwave.New("my-service", microwave.GRPCAdminPort("99999"), microwave.GRPCReflection())
+	test.Equal(microwave.ErrListenAddrInvalid, pkgerrors.Cause(err))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !test.Nil(err) {
+		return
+	}
+	adminAddr := lis.Addr().String()
+	_ = lis.Close()
+
+	rec := logtest.New("my-service")
+	mw, err := microwave.New("my-service",
+		microwave.CustomLogger(rec),
+		microwave.GRPCReflection(),
+		microwave.GRPCAdminPort(adminAddr),
+		microwave.GRPCDebugAuth(func(ctx context.Context) error {
+			md, _ := metadata.FromIncomingContext(ctx)
+			if len(md.Get("x-debug-token")) == 0 || md.Get("x-debug-token")[0] != "secret" {
+				return errors.New("debug token invalid")
+			}
+			return nil
+		}),
+	)
+	if !test.Nil(err) {
+		return
+	}
+
+	conn, stop := startDebug(t, mw)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = testpb.NewTestServiceClient(conn).UnaryCall(ctx, &testpb.SimpleRequest{}, grpc.WaitForReady(true))
+	test.Nil(err)
+
+	// not served on the application port
+	_, err = listServices(ctx, conn)
+	test.Equal(codes.Unimplemented, status.Code(err))
+
+	admin, err := grpc.Dial(adminAddr, grpc.WithInsecure())
+	if !test.Nil(err) {
+		return
+	}
+	defer admin.Close()
+
+	_, err = listServices(ctx, admin)
+	test.Equal(codes.PermissionDenied, status.Code(err))
+
+	services, err := listServices(metadata.AppendToOutgoingContext(ctx, "x-debug-token", "secret"), admin)
+	if test.Nil(err) {
+		// the application services aren't served there
+		test.Equal([]string{"grpc.reflection.v1alpha.ServerReflection"}, services)
+	}
+
+	rec.HasEntry(t, log.LevelInfo, "GRPC_SERVER_STARTED", log.F("port", adminAddr))
+}
@@ -0,0 +1,193 @@
+package microwave
+
+import (
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/pkg/errors"
+	"github.com/sqrt-7/microwave/log"
+	"go.opencensus.io/plugin/ocgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// NewDefaultGRPCServer defaults
+const (
+	defaultGRPCMaxMsgSize           = 4 << 20
+	defaultGRPCMaxConcurrentStreams = 1000
+	defaultGRPCConnectionTimeout    = 20 * time.Second
+)
+
+var (
+	ErrGRPCOptionInvalid = errors.New("grpc server option invalid")
+)
+
+type GRPCOption interface {
+	apply(*grpcConfig) error
+}
+
+type grpcOptionFn func(*grpcConfig) error
+
+func (o grpcOptionFn) apply(c *grpcConfig) error {
+	return o(c)
+}
+
+type grpcConfig struct {
+	keepalive            keepalive.ServerParameters
+	enforcement          keepalive.EnforcementPolicy
+	maxRecvMsgSize       int
+	maxSendMsgSize       int
+	maxConcurrentStreams uint32
+	connectionTimeout    time.Duration
+
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+	serverOptions      []grpc.ServerOption
+}
+
+func defaultGRPCConfig() *grpcConfig {
+	return &grpcConfig{
+		keepalive: keepalive.ServerParameters{
+			// no MaxConnectionAge like grpc, it would end long streams
+			MaxConnectionIdle: 15 * time.Minute,
+			Time:              5 * time.Minute,
+			Timeout:           20 * time.Second,
+		},
+		enforcement: keepalive.EnforcementPolicy{
+			MinTime: time.Minute,
+		},
+		maxRecvMsgSize:       defaultGRPCMaxMsgSize,
+		maxSendMsgSize:       defaultGRPCMaxMsgSize,
+		maxConcurrentStreams: defaultGRPCMaxConcurrentStreams,
+		connectionTimeout:    defaultGRPCConnectionTimeout,
+	}
+}
+
+// GRPCKeepalive replaces the connection keepalive & age parameters (idle 15m, no max age,
+// ping after 5m without activity, 20s ping timeout). The whole struct is replaced, unset fields
+// get grpc's defaults & not the ones above. A MaxConnectionAge spreads the load over new server
+// instances, but ends the streams still running after MaxConnectionAge + MaxConnectionAgeGrace
+func GRPCKeepalive(params keepalive.ServerParameters) GRPCOption {
+	return grpcOptionFn(func(c *grpcConfig) error {
+		if params.MaxConnectionIdle < 0 || params.MaxConnectionAge < 0 || params.MaxConnectionAgeGrace < 0 ||
+			params.Time < 0 || params.Timeout < 0 {
+			return errors.Wrap(ErrGRPCOptionInvalid, "negative keepalive duration")
+		}
+
+		c.keepalive = params
+		return nil
+	})
+}
+
+// GRPCKeepaliveEnforcement replaces the policy for client pings (at most one per minute, only with active streams),
+// misbehaving clients are disconnected
+func GRPCKeepaliveEnforcement(policy keepalive.EnforcementPolicy) GRPCOption {
+	return grpcOptionFn(func(c *grpcConfig) error {
+		if policy.MinTime < 0 {
+			return errors.Wrap(ErrGRPCOptionInvalid, "negative keepalive enforcement min time")
+		}
+
+		c.enforcement = policy
+		return nil
+	})
+}
+
+// GRPCMaxRecvMsgSize sets the max size of received messages in bytes (4MB)
+func GRPCMaxRecvMsgSize(size int) GRPCOption {
+	return grpcOptionFn(func(c *grpcConfig) error {
+		if size <= 0 {
+			return errors.Wrapf(ErrGRPCOptionInvalid, "max recv message size %d", size)
+		}
+
+		c.maxRecvMsgSize = size
+		return nil
+	})
+}
+
+// GRPCMaxSendMsgSize sets the max size of sent messages in bytes (4MB)
+func GRPCMaxSendMsgSize(size int) GRPCOption {
+	return grpcOptionFn(func(c *grpcConfig) error {
+		if size <= 0 {
+			return errors.Wrapf(ErrGRPCOptionInvalid, "max send message size %d", size)
+		}
+
+		c.maxSendMsgSize = size
+		return nil
+	})
+}
+
+// GRPCMaxConcurrentStreams limits the concurrent streams (calls) per connection (1000)
+func GRPCMaxConcurrentStreams(n uint32) GRPCOption {
+	return grpcOptionFn(func(c *grpcConfig) error {
+		if n == 0 {
+			return errors.Wrap(ErrGRPCOptionInvalid, "max concurrent streams 0")
+		}
+
+		c.maxConcurrentStreams = n
+		return nil
+	})
+}
+
+// GRPCConnectionTimeout limits the connection setup, including the TLS handshake (20s)
+func GRPCConnectionTimeout(timeout time.Duration) GRPCOption {
+	return grpcOptionFn(func(c *grpcConfig) error {
+		if timeout <= 0 {
+			return errors.Wrapf(ErrGRPCOptionInvalid, "connection timeout %s", timeout)
+		}
+
+		c.connectionTimeout = timeout
+		return nil
+	})
+}
+
+// GRPCInterceptors adds interceptors, which run after the default ones
+func GRPCInterceptors(unary []grpc.UnaryServerInterceptor, stream []grpc.StreamServerInterceptor) GRPCOption {
+	return grpcOptionFn(func(c *grpcConfig) error {
+		c.unaryInterceptors = append(c.unaryInterceptors, unary...)
+		c.streamInterceptors = append(c.streamInterceptors, stream...)
+		return nil
+	})
+}
+
+// GRPCServerOptions adds grpc.ServerOptions (e.g. TLSConfig.GRPCServerOption),
+// applied after the ones set by the other options
+func GRPCServerOptions(opts ...grpc.ServerOption) GRPCOption {
+	return grpcOptionFn(func(c *grpcConfig) error {
+		c.serverOptions = append(c.serverOptions, opts...)
+		return nil
+	})
+}
+
+// NewDefaultGRPCServer creates a server with the default interceptors (see DefaultGRPCInterceptors),
+// the stats handler & production defaults for keepalive, message sizes & concurrency
+func NewDefaultGRPCServer(logger log.Logger, options ...GRPCOption) (*grpc.Server, error) {
+	cfg := defaultGRPCConfig()
+	for _, opt := range options {
+		if err := opt.apply(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	unary, stream := DefaultGRPCInterceptors(logger)
+	unary = append(unary, cfg.unaryInterceptors...)
+	stream = append(stream, cfg.streamInterceptors...)
+
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unary...)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(stream...)),
+		grpcStatsHandler(),
+		grpc.KeepaliveParams(cfg.keepalive),
+		grpc.KeepaliveEnforcementPolicy(cfg.enforcement),
+		grpc.MaxRecvMsgSize(cfg.maxRecvMsgSize),
+		grpc.MaxSendMsgSize(cfg.maxSendMsgSize),
+		grpc.MaxConcurrentStreams(cfg.maxConcurrentStreams),
+		grpc.ConnectionTimeout(cfg.connectionTimeout),
+	}
+
+	return grpc.NewServer(append(opts, cfg.serverOptions...)...), nil
+}
+
+// grpcStatsHandler enables TraceID propagation, with W3C traceparent support
+func grpcStatsHandler() grpc.ServerOption {
+	return grpc.StatsHandler(traceParentHandler{&ocgrpc.ServerHandler{}})
+}
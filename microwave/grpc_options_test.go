@@ -0,0 +1,98 @@
+package microwave_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sqrt-7/microwave/log"
+	"github.com/sqrt-7/microwave/log/logtest"
+	"github.com/sqrt-7/microwave/microwave"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestNewDefaultGRPCServer(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+
+	invalid := []microwave.GRPCOption{
+		microwave.GRPCKeepalive(keepalive.ServerParameters{Time: -time.Second}),
+		microwave.GRPCKeepaliveEnforcement(keepalive.EnforcementPolicy{MinTime: -time.Second}),
+		microwave.GRPCMaxRecvMsgSize(0),
+		microwave.GRPCMaxSendMsgSize(-1),
+		microwave.GRPCMaxConcurrentStreams(0),
+		microwave.GRPCConnectionTimeout(0),
+	}
+	for i, opt := range invalid {
+		_, err := microwave.NewDefaultGRPCServer(rec, opt)
+		test.Equal(microwave.ErrGRPCOptionInvalid, errors.Cause(err), i)
+	}
+
+	// runs after the default interceptors, with the request id already set
+	var intercepted []string
+	interceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		intercepted = append(intercepted, info.FullMethod)
+		return handler(ctx, req)
+	}
+
+	srv, err := microwave.NewDefaultGRPCServer(rec,
+		microwave.GRPCMaxRecvMsgSize(1024),
+		microwave.GRPCMaxSendMsgSize(2048),
+		microwave.GRPCMaxConcurrentStreams(10),
+		microwave.GRPCKeepalive(keepalive.ServerParameters{MaxConnectionIdle: time.Minute}),
+		microwave.GRPCInterceptors([]grpc.UnaryServerInterceptor{interceptor}, nil),
+	)
+	if !test.Nil(err) {
+		return
+	}
+	testpb.RegisterTestServiceServer(srv, &testServer{})
+
+	lis := bufconn.Listen(1024 * 1024)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if !test.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	client := testpb.NewTestServiceClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-1")
+	unary := "/grpc.testing.TestService/UnaryCall"
+
+	resp, err := client.UnaryCall(ctx, &testpb.SimpleRequest{Payload: &testpb.Payload{Body: []byte("ping")}})
+	if test.Nil(err) {
+		test.Equal("ping", string(resp.Payload.Body))
+	}
+	test.Equal([]string{unary}, intercepted)
+	rec.HasEntry(t, log.LevelInfo, "GRPC_IN", log.F("RequestID", "req-1"), log.F("method", unary))
+
+	_, err = client.UnaryCall(ctx, &testpb.SimpleRequest{Payload: &testpb.Payload{Body: bytes.Repeat([]byte("x"), 2048)}})
+	test.Equal(codes.ResourceExhausted, status.Code(err))
+
+	stream, err := client.StreamingOutputCall(ctx, &testpb.StreamingOutputCallRequest{
+		ResponseParameters: []*testpb.ResponseParameters{{Size: 100}, {Size: 4096}},
+	})
+	if !test.Nil(err) {
+		return
+	}
+	_, err = stream.Recv()
+	test.Nil(err)
+	_, err = stream.Recv()
+	test.Equal(codes.ResourceExhausted, status.Code(err))
+}
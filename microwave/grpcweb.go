@@ -0,0 +1,503 @@
+package microwave
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// length-prefixed message flags
+	grpcWebDataFrame    byte = 0x00
+	grpcWebTrailerFrame byte = 0x80
+
+	// max request body read by GRPCWeb
+	grpcWebMaxBodySize = 4 << 20
+)
+
+var (
+	ErrGRPCWebServerMissing = errors.New("grpc-web grpc server missing")
+	ErrGRPCWebConnMissing   = errors.New("grpc-web grpc client connection missing")
+)
+
+// request headers which are not gRPC metadata (with the hop-by-hop ones),
+// the reserved "grpc-" headers are skipped too
+var grpcWebSkipHeaders = map[string]bool{
+	"accept":              true,
+	"accept-encoding":     true,
+	"accept-language":     true,
+	"connection":          true,
+	"content-length":      true,
+	"content-type":        true,
+	"cookie":              true,
+	"host":                true,
+	"keep-alive":          true,
+	"origin":              true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"proxy-connection":    true,
+	"referer":             true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+	"user-agent":          true,
+	"x-grpc-web":          true,
+	"x-user-agent":        true,
+}
+
+// GRPCWebConfig configures NewGRPCWeb
+type GRPCWebConfig struct {
+	AllowedOrigins []string // CORS origins allowed to call the services with credentials ("*" for any without credentials, none disables CORS)
+	AllowedHeaders []string // request headers allowed by CORS, added to the grpc-web & log context headers
+	ExposedHeaders []string // response metadata readable by browsers, added to grpc-status & grpc-message
+}
+
+// GRPCWeb serves gRPC-Web (binary & text) requests for the unary & server streaming methods
+// registered on a gRPC server. Calls are proxied through conn to the server, so they go
+// through the same interceptors & stats handler as native gRPC calls
+type GRPCWeb struct {
+	conn    grpc.ClientConnInterface
+	methods map[string]grpc.MethodInfo
+
+	allowAnyOrigin bool
+	allowedOrigins map[string]bool
+	allowedHeaders string
+	exposedHeaders string
+}
+
+// NewGRPCWeb serves the methods registered on server, calls are sent through conn
+// (e.g. a connection to the server's own port)
+func NewGRPCWeb(server *grpc.Server, conn grpc.ClientConnInterface, cfg GRPCWebConfig) (*GRPCWeb, error) {
+	if server == nil {
+		return nil, ErrGRPCWebServerMissing
+	}
+
+	if conn == nil {
+		return nil, ErrGRPCWebConnMissing
+	}
+
+	g := &GRPCWeb{
+		conn:           conn,
+		methods:        make(map[string]grpc.MethodInfo),
+		allowedOrigins: make(map[string]bool),
+	}
+
+	for service, info := range server.GetServiceInfo() {
+		for _, m := range info.Methods {
+			g.methods[fmt.Sprintf("/%s/%s", service, m.Name)] = m
+		}
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			g.allowAnyOrigin = true
+		}
+		g.allowedOrigins[origin] = true
+	}
+
+	allowed := []string{"content-type", "x-grpc-web", "x-user-agent", "grpc-timeout", "authorization", "traceparent"}
+	for _, h := range contextFieldHeaders {
+		allowed = append(allowed, h.header)
+	}
+	g.allowedHeaders = strings.Join(append(allowed, cfg.AllowedHeaders...), ", ")
+	g.exposedHeaders = strings.Join(append([]string{"grpc-status", "grpc-message"}, cfg.ExposedHeaders...), ", ")
+
+	return g, nil
+}
+
+// Middleware serves the gRPC-Web requests (& their CORS preflights), other requests go to next
+func (g *GRPCWeb) Middleware() HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if g.isGRPCWebRequest(r) || g.isPreflight(r) {
+				g.ServeHTTP(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (g *GRPCWeb) isGRPCWebRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+func (g *GRPCWeb) isPreflight(r *http.Request) bool {
+	_, ok := g.methods[r.URL.Path]
+	return ok && r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (g *GRPCWeb) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	originAllowed := g.setCORSHeaders(w, r)
+
+	if g.isPreflight(r) {
+		if !originAllowed {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", g.allowedHeaders)
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !g.isGRPCWebRequest(r) {
+		writeHTTPError(w, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	text := strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebTextContentType)
+	resp := &grpcWebResponse{w: w, text: text}
+
+	info, ok := g.methods[r.URL.Path]
+	if !ok || info.IsClientStream {
+		resp.finish(nil, status.Newf(codes.Unimplemented, "unknown method %s", r.URL.Path))
+		return
+	}
+
+	msg, err := readGRPCWebRequest(r, text)
+	if err != nil {
+		resp.finish(nil, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	ctx, cancel, err := g.outgoingContext(r)
+	if err != nil {
+		resp.finish(nil, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+	defer cancel()
+
+	stream, err := g.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, r.URL.Path, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		resp.finish(nil, status.Convert(err))
+		return
+	}
+
+	if err := stream.SendMsg(&msg); err != nil && err != io.EOF {
+		resp.finish(stream.Trailer(), status.Convert(err))
+		return
+	}
+	if err := stream.CloseSend(); err != nil {
+		resp.finish(stream.Trailer(), status.Convert(err))
+		return
+	}
+
+	for {
+		var out []byte
+		err := stream.RecvMsg(&out)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			resp.finish(stream.Trailer(), status.Convert(err))
+			return
+		}
+
+		if !resp.started {
+			header, _ := stream.Header()
+			resp.start(header)
+		}
+		resp.writeFrame(grpcWebDataFrame, out)
+	}
+
+	if !resp.started {
+		header, _ := stream.Header()
+		resp.start(header)
+	}
+	resp.finish(stream.Trailer(), status.New(codes.OK, ""))
+}
+
+// setCORSHeaders reports whether the request origin is allowed
+func (g *GRPCWeb) setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// same origin or not a browser
+		return true
+	}
+
+	switch {
+	case g.allowedOrigins[origin] && origin != "*":
+		// credentials (cookies, HTTP auth, client certificates) only for the listed origins
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Add("Vary", "Origin")
+	case g.allowAnyOrigin:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	default:
+		return false
+	}
+
+	w.Header().Set("Access-Control-Expose-Headers", g.exposedHeaders)
+	return true
+}
+
+// outgoingContext sends the request headers as metadata, with the grpc-timeout deadline
+func (g *GRPCWeb) outgoingContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	// headers listed in Connection are hop-by-hop too
+	hopByHop := map[string]bool{}
+	for _, v := range r.Header.Values("Connection") {
+		for _, key := range strings.Split(v, ",") {
+			hopByHop[strings.ToLower(strings.TrimSpace(key))] = true
+		}
+	}
+
+	md := metadata.MD{}
+	for key, values := range r.Header {
+		key = strings.ToLower(key)
+		if grpcWebSkipHeaders[key] || hopByHop[key] || strings.HasPrefix(key, "grpc-") ||
+			strings.HasPrefix(key, "sec-") || strings.HasPrefix(key, "access-control-") {
+			continue
+		}
+
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				b, err := decodeBinHeader(v)
+				if err != nil {
+					return nil, nil, errors.Errorf("invalid binary header %s", key)
+				}
+				v = string(b)
+			}
+			md.Append(key, v)
+		}
+	}
+	setTraceParent(md, r)
+
+	ctx := metadata.NewOutgoingContext(r.Context(), md)
+
+	if v := r.Header.Get("grpc-timeout"); v != "" {
+		timeout, err := parseGRPCTimeout(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return ctx, cancel, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, nil
+}
+
+// readGRPCWebRequest returns the single message of the request body
+func readGRPCWebRequest(r *http.Request, text bool) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, grpcWebMaxBodySize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "read body")
+	}
+	if len(body) > grpcWebMaxBodySize {
+		return nil, errors.New("request body too large")
+	}
+
+	if text {
+		if body, err = decodeGRPCWebText(body); err != nil {
+			return nil, errors.Wrap(err, "invalid base64 body")
+		}
+	}
+
+	if len(body) < 5 {
+		return nil, errors.New("missing message frame")
+	}
+
+	if body[0] != grpcWebDataFrame {
+		return nil, errors.New("compressed or unknown message frame")
+	}
+
+	size := binary.BigEndian.Uint32(body[1:5])
+	if uint32(len(body)-5) != size {
+		return nil, errors.New("message frame length mismatch")
+	}
+
+	return body[5:], nil
+}
+
+// decodeGRPCWebText decodes base64 text, which may be padded chunks joined together
+func decodeGRPCWebText(body []byte) ([]byte, error) {
+	s := strings.Join(strings.Fields(string(body)), "")
+
+	var out []byte
+	for len(s) > 0 {
+		end := len(s)
+		if i := strings.IndexByte(s, '='); i >= 0 {
+			end = i
+			for end < len(s) && s[end] == '=' {
+				end++
+			}
+		}
+
+		chunk, err := base64.StdEncoding.DecodeString(s[:end])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, chunk...)
+		s = s[end:]
+	}
+
+	return out, nil
+}
+
+// grpcWebResponse writes headers, length-prefixed messages & the trailer frame
+type grpcWebResponse struct {
+	w       http.ResponseWriter
+	text    bool
+	started bool
+}
+
+func (r *grpcWebResponse) start(header metadata.MD) {
+	r.started = true
+
+	contentType := grpcWebContentType + "+proto"
+	if r.text {
+		contentType = grpcWebTextContentType + "+proto"
+	}
+
+	h := r.w.Header()
+	for k, vs := range header {
+		if k == "content-type" {
+			continue
+		}
+		for _, v := range vs {
+			h.Add(k, encodeMetadataValue(k, v))
+		}
+	}
+	h.Set("Content-Type", contentType)
+	r.w.WriteHeader(http.StatusOK)
+}
+
+func (r *grpcWebResponse) writeFrame(flag byte, data []byte) {
+	frame := make([]byte, 5+len(data))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+
+	if r.text {
+		frame = []byte(base64.StdEncoding.EncodeToString(frame))
+	}
+
+	_, _ = r.w.Write(frame)
+	if f, ok := r.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the status & trailers as the trailer frame
+func (r *grpcWebResponse) finish(trailer metadata.MD, st *status.Status) {
+	if !r.started {
+		r.start(nil)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "grpc-status: %d\r\n", st.Code())
+	if st.Message() != "" {
+		fmt.Fprintf(buf, "grpc-message: %s\r\n", encodeGRPCMessage(st.Message()))
+	}
+	for k, vs := range trailer {
+		for _, v := range vs {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, encodeMetadataValue(k, v))
+		}
+	}
+
+	r.writeFrame(grpcWebTrailerFrame, buf.Bytes())
+}
+
+// rawCodec passes the serialized messages through (the server still uses the proto codec)
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, errors.Errorf("raw codec: unexpected type %T", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return errors.Errorf("raw codec: unexpected type %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// parseGRPCTimeout parses the grpc-timeout header ("100m", "5S"...),
+// timeouts too long for a time.Duration are capped like grpc-go does
+func parseGRPCTimeout(v string) (time.Duration, error) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, errors.Errorf("invalid grpc-timeout %q", v)
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+
+	unit, ok := units[v[len(v)-1]]
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, errors.Errorf("invalid grpc-timeout %q", v)
+	}
+
+	if n > math.MaxInt64/int64(unit) {
+		return math.MaxInt64, nil
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+// binary metadata is base64 in headers, padded or not
+func decodeBinHeader(v string) ([]byte, error) {
+	if len(v)%4 == 0 {
+		return base64.StdEncoding.DecodeString(v)
+	}
+	return base64.RawStdEncoding.DecodeString(v)
+}
+
+func encodeMetadataValue(key string, v string) string {
+	if strings.HasSuffix(key, "-bin") {
+		return base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	return v
+}
+
+// encodeGRPCMessage percent-encodes grpc-message like gRPC does
+func encodeGRPCMessage(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
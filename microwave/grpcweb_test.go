@@ -0,0 +1,289 @@
+package microwave_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sqrt-7/microwave/log"
+	"github.com/sqrt-7/microwave/log/logtest"
+	"github.com/sqrt-7/microwave/microwave"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// testServer echoes payloads, with the requested status & sizes
+type testServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (s *testServer) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-served-by", "test"))
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(
+		"x-token", strings.Join(md.Get("x-token"), ","),
+		"x-grpc-custom", strings.Join(md.Get("grpc-custom"), ","),
+		"x-upgrade", strings.Join(md.Get("upgrade"), ","),
+	))
+
+	if st := req.ResponseStatus; st != nil {
+		return nil, status.Error(codes.Code(st.Code), st.Message)
+	}
+
+	return &testpb.SimpleResponse{Payload: req.Payload}, nil
+}
+
+func (s *testServer) StreamingOutputCall(req *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	for _, p := range req.ResponseParameters {
+		resp := &testpb.StreamingOutputCallResponse{Payload: &testpb.Payload{Body: bytes.Repeat([]byte("x"), int(p.Size))}}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func grpcWebRequest(t *testing.T, method string, msg proto.Message, text bool) *http.Request {
+	b, err := proto.Marshal(msg)
+	assert.Nil(t, err)
+
+	body := make([]byte, 5+len(b))
+	binary.BigEndian.PutUint32(body[1:5], uint32(len(b)))
+	copy(body[5:], b)
+
+	contentType := "application/grpc-web+proto"
+	if text {
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+		contentType = "application/grpc-web-text+proto"
+	}
+
+	req := httptest.NewRequest(http.MethodPost, method, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+	return req
+}
+
+// grpcWebResponse splits the response body into messages & the trailer frame
+func grpcWebResponse(t *testing.T, resp *httptest.ResponseRecorder, text bool) ([][]byte, string) {
+	body := resp.Body.Bytes()
+	if text {
+		// frames are encoded separately, each one padded
+		var decoded []byte
+		s := string(body)
+		for len(s) > 0 {
+			end := strings.IndexByte(s, '=')
+			if end < 0 {
+				end = len(s)
+			}
+			for end < len(s) && s[end] == '=' {
+				end++
+			}
+			b, err := base64.StdEncoding.DecodeString(s[:end])
+			if !assert.Nil(t, err) {
+				return nil, ""
+			}
+			decoded = append(decoded, b...)
+			s = s[end:]
+		}
+		body = decoded
+	}
+
+	var msgs [][]byte
+	var trailer string
+	for len(body) >= 5 {
+		size := binary.BigEndian.Uint32(body[1:5])
+		frame := body[5 : 5+size]
+		if body[0]&0x80 != 0 {
+			trailer = string(frame)
+		} else {
+			msgs = append(msgs, frame)
+		}
+		body = body[5+size:]
+	}
+
+	return msgs, trailer
+}
+
+func TestGRPCWeb(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := microwave.NewGRPCServer(microwave.DefaultGRPCInterceptors(rec))
+	testpb.RegisterTestServiceServer(srv, &testServer{})
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if !test.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	_, err = microwave.NewGRPCWeb(nil, conn, microwave.GRPCWebConfig{})
+	test.Equal(microwave.ErrGRPCWebServerMissing, err)
+	_, err = microwave.NewGRPCWeb(srv, nil, microwave.GRPCWebConfig{})
+	test.Equal(microwave.ErrGRPCWebConnMissing, err)
+
+	web, err := microwave.NewGRPCWeb(srv, conn, microwave.GRPCWebConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		ExposedHeaders: []string{"x-served-by"},
+	})
+	if !test.Nil(err) {
+		return
+	}
+
+	router := microwave.NewRouter()
+	router.HandleFunc(http.MethodGet, "/ping", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	})
+	handler := microwave.NewHTTPServer(router, append(microwave.DefaultHTTPMiddlewares(rec), web.Middleware())).Handler
+
+	const unary = "/grpc.testing.TestService/UnaryCall"
+
+	for _, text := range []bool{false, true} {
+		req := grpcWebRequest(t, unary, &testpb.SimpleRequest{Payload: &testpb.Payload{Body: []byte("hello")}}, text)
+		req.Header.Set("X-Token", "t-1")
+		req.Header.Set("X-Request-Id", "req-1")
+		req.Header.Set("Grpc-Custom", "reserved")
+		req.Header.Set("Upgrade", "h2c")
+		req.Header.Set("Grpc-Timeout", "99999999H")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		test.Equal(http.StatusOK, resp.Code)
+		test.Equal("test", resp.Header().Get("X-Served-By"))
+		if text {
+			test.Equal("application/grpc-web-text+proto", resp.Header().Get("Content-Type"))
+		} else {
+			test.Equal("application/grpc-web+proto", resp.Header().Get("Content-Type"))
+		}
+
+		msgs, trailer := grpcWebResponse(t, resp, text)
+		if test.Len(msgs, 1) {
+			out := &testpb.SimpleResponse{}
+			test.Nil(proto.Unmarshal(msgs[0], out))
+			test.Equal("hello", string(out.Payload.Body))
+		}
+		test.Contains(trailer, "grpc-status: 0\r\n")
+		test.Contains(trailer, "x-token: t-1\r\n")
+		// reserved & hop-by-hop headers aren't metadata, the huge timeout is capped (not negative)
+		test.NotContains(trailer, "reserved")
+		test.NotContains(trailer, "h2c")
+	}
+
+	// the call goes through the gRPC interceptors, with the request ID
+	rec.HasEntry(t, log.LevelInfo, "GRPC_IN", log.F("RequestID", "req-1"), log.F("method", unary))
+
+	// server streaming
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, grpcWebRequest(t, "/grpc.testing.TestService/StreamingOutputCall", &testpb.StreamingOutputCallRequest{
+		ResponseParameters: []*testpb.ResponseParameters{{Size: 1}, {Size: 2}, {Size: 3}},
+	}, true))
+	msgs, trailer := grpcWebResponse(t, resp, true)
+	if test.Len(msgs, 3) {
+		for i, msg := range msgs {
+			out := &testpb.StreamingOutputCallResponse{}
+			test.Nil(proto.Unmarshal(msg, out))
+			test.Len(out.Payload.Body, i+1)
+		}
+	}
+	test.Contains(trailer, "grpc-status: 0\r\n")
+
+	// error status
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, grpcWebRequest(t, unary, &testpb.SimpleRequest{
+		ResponseStatus: &testpb.EchoStatus{Code: int32(codes.NotFound), Message: "not here: 100%"},
+	}, false))
+	test.Equal(http.StatusOK, resp.Code)
+	msgs, trailer = grpcWebResponse(t, resp, false)
+	test.Len(msgs, 0)
+	test.Contains(trailer, "grpc-status: 5\r\n")
+	test.Contains(trailer, "grpc-message: not here: 100%25\r\n")
+
+	// unknown & client streaming methods
+	for _, method := range []string{"/grpc.testing.TestService/Missing", "/grpc.testing.TestService/StreamingInputCall"} {
+		resp = httptest.NewRecorder()
+		handler.ServeHTTP(resp, grpcWebRequest(t, method, &testpb.SimpleRequest{}, false))
+		_, trailer = grpcWebResponse(t, resp, false)
+		test.Contains(trailer, "grpc-status: 12\r\n", method)
+	}
+
+	// invalid frame
+	req := httptest.NewRequest(http.MethodPost, unary, strings.NewReader("abc"))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	_, trailer = grpcWebResponse(t, resp, false)
+	test.Contains(trailer, "grpc-status: 3\r\n")
+
+	// CORS
+	for _, tt := range []struct {
+		origin string
+		status int
+		allow  string
+	}{
+		{origin: "https://app.example.com", status: http.StatusNoContent, allow: "https://app.example.com"},
+		{origin: "https://evil.example.com", status: http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodOptions, unary, nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		test.Equal(tt.status, resp.Code, tt.origin)
+		test.Equal(tt.allow, resp.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+		if tt.allow != "" {
+			test.Contains(resp.Header().Get("Access-Control-Allow-Headers"), "x-grpc-web")
+			test.Contains(resp.Header().Get("Access-Control-Expose-Headers"), "grpc-status")
+			test.Contains(resp.Header().Get("Access-Control-Expose-Headers"), "x-served-by")
+		}
+	}
+
+	// any origin without credentials, the listed ones with credentials
+	anyOrigin, err := microwave.NewGRPCWeb(srv, conn, microwave.GRPCWebConfig{AllowedOrigins: []string{"*", "https://app.example.com"}})
+	if !test.Nil(err) {
+		return
+	}
+	for _, tt := range []struct {
+		origin      string
+		allow       string
+		credentials string
+	}{
+		{origin: "https://app.example.com", allow: "https://app.example.com", credentials: "true"},
+		{origin: "https://evil.example.com", allow: "*"},
+	} {
+		req := httptest.NewRequest(http.MethodOptions, unary, nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		resp := httptest.NewRecorder()
+		anyOrigin.ServeHTTP(resp, req)
+
+		test.Equal(http.StatusNoContent, resp.Code, tt.origin)
+		test.Equal(tt.allow, resp.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+		test.Equal(tt.credentials, resp.Header().Get("Access-Control-Allow-Credentials"), tt.origin)
+	}
+
+	// other requests go to the router
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	test.Equal("pong", resp.Body.String())
+}
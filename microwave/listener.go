@@ -0,0 +1,139 @@
+package microwave
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	unixAddrPrefix = "unix://"
+
+	// dial timeout checking whether an existing socket file is still served
+	unixSocketProbeTimeout = 100 * time.Millisecond
+)
+
+var (
+	ErrServerMissing         = errors.New("server missing")
+	ErrListenAddrMissing     = errors.New("listen address or listener missing")
+	ErrListenAddrInvalid     = errors.New("listen address invalid")
+	ErrListenAddrAndListener = errors.New("both listen address and listener set")
+	ErrUnixSocketInUse       = errors.New("unix socket in use")
+)
+
+// listenAddr is a parsed wrapper address: a port ("8080"), host:port (":8080",
+// "127.0.0.1:8080", "[::1]:8080") or a unix socket ("unix:///run/app.sock")
+type listenAddr struct {
+	network string
+	address string
+}
+
+func (a listenAddr) String() string {
+	if a.network == "unix" {
+		return unixAddrPrefix + a.address
+	}
+	return a.address
+}
+
+func parseListenAddr(addr string) (listenAddr, error) {
+	if addr == "" {
+		return listenAddr{}, ErrListenAddrMissing
+	}
+
+	if strings.HasPrefix(addr, unixAddrPrefix) {
+		path := strings.TrimPrefix(addr, unixAddrPrefix)
+		if path == "" {
+			return listenAddr{}, errors.Wrap(ErrListenAddrInvalid, addr)
+		}
+		return listenAddr{network: "unix", address: path}, nil
+	}
+
+	// a port alone listens on all interfaces
+	if _, err := strconv.ParseUint(addr, 10, 16); err == nil {
+		addr = ":" + addr
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return listenAddr{}, errors.Wrap(ErrListenAddrInvalid, addr)
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return listenAddr{}, errors.Wrap(ErrListenAddrInvalid, addr)
+	}
+
+	return listenAddr{network: "tcp", address: addr}, nil
+}
+
+// validateListen checks the wrapper has exactly one of an address & a listener
+func validateListen(port string, lis net.Listener) error {
+	if lis != nil {
+		if port != "" {
+			return ErrListenAddrAndListener
+		}
+		return nil
+	}
+
+	_, err := parseListenAddr(port)
+	return err
+}
+
+// serverListener returns the injected listener or listens on port, with the address to log.
+// cleanup removes the unix socket file once the server is stopped
+func serverListener(port string, lis net.Listener) (net.Listener, string, func(), error) {
+	noCleanup := func() {}
+
+	if err := validateListen(port, lis); err != nil {
+		return nil, "", noCleanup, err
+	}
+
+	if lis != nil {
+		return lis, lis.Addr().String(), noCleanup, nil
+	}
+
+	addr, _ := parseListenAddr(port)
+	if addr.network == "unix" {
+		if err := removeStaleSocket(addr.address); err != nil {
+			return nil, "", noCleanup, err
+		}
+	}
+
+	lis, err := net.Listen(addr.network, addr.address)
+	if err != nil {
+		return nil, "", noCleanup, err
+	}
+
+	return lis, addr.String(), socketCleanup(addr), nil
+}
+
+// socketCleanup removes the unix socket file
+func socketCleanup(addr listenAddr) func() {
+	if addr.network != "unix" {
+		return func() {}
+	}
+
+	return func() {
+		// closing the listener usually unlinks it already
+		_ = os.Remove(addr.address)
+	}
+}
+
+// removeStaleSocket removes a socket file left by a previous process,
+// sockets still accepting connections are not touched
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		// missing, or not a socket & net.Listen reports it
+		return nil
+	}
+
+	if conn, err := net.DialTimeout("unix", path, unixSocketProbeTimeout); err == nil {
+		_ = conn.Close()
+		return errors.Wrap(ErrUnixSocketInUse, path)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,157 @@
+package microwave_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sqrt-7/microwave/log"
+	"github.com/sqrt-7/microwave/log/logtest"
+	"github.com/sqrt-7/microwave/microwave"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestAddServer(t *testing.T) {
+	test := assert.New(t)
+
+	mw, err := microwave.New("my-service", microwave.CustomLogger(logtest.New("my-service")))
+	if !test.Nil(err) {
+		return
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !test.Nil(err) {
+		return
+	}
+	defer lis.Close()
+
+	grpcSrv := grpc.NewServer()
+	httpSrv := &http.Server{}
+
+	tests := []struct {
+		name    string
+		wrapper microwave.ServerWrapper
+		err     error
+	}{
+		{name: "port", wrapper: microwave.GRPCWrapper{Port: "8080", Server: grpcSrv}},
+		{name: ":port", wrapper: microwave.HTTPWrapper{Port: ":8080", Server: httpSrv}},
+		{name: "host:port", wrapper: microwave.HTTPWrapper{Port: "localhost:http", Server: httpSrv}},
+		{name: "IPv6", wrapper: microwave.GRPCWrapper{Port: "[::1]:8080", Server: grpcSrv}},
+		{name: "unix socket", wrapper: microwave.MuxWrapper{Port: "unix:///run/app.sock", GRPCServer: grpcSrv, HTTPServer: httpSrv}},
+		{name: "listener", wrapper: microwave.GRPCWrapper{Listener: lis, Server: grpcSrv}},
+		{name: "custom wrapper", wrapper: TestWrapper{}},
+		{name: "empty", wrapper: microwave.GRPCWrapper{Server: grpcSrv}, err: microwave.ErrListenAddrMissing},
+		{name: "IPv6 without brackets", wrapper: microwave.GRPCWrapper{Port: "::1:8080", Server: grpcSrv}, err: microwave.ErrListenAddrInvalid},
+		{name: "port out of range", wrapper: microwave.HTTPWrapper{Port: "99999", Server: httpSrv}, err: microwave.ErrListenAddrInvalid},
+		{name: "unknown service", wrapper: microwave.HTTPWrapper{Port: "localhost:nope", Server: httpSrv}, err: microwave.ErrListenAddrInvalid},
+		{name: "unix without path", wrapper: microwave.HTTPWrapper{Port: "unix://", Server: httpSrv}, err: microwave.ErrListenAddrInvalid},
+		{name: "port & listener", wrapper: microwave.HTTPWrapper{Port: "8080", Listener: lis, Server: httpSrv}, err: microwave.ErrListenAddrAndListener},
+		{name: "server missing", wrapper: microwave.MuxWrapper{Port: "8080", GRPCServer: grpcSrv}, err: microwave.ErrServerMissing},
+	}
+
+	for _, tt := range tests {
+		err := mw.AddServer(tt.wrapper)
+		test.Equal(tt.err, errors.Cause(err), tt.name)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+	mw, err := microwave.New("my-service", microwave.CustomLogger(rec))
+	if !test.Nil(err) {
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "microwave-unix")
+	if !test.Nil(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// a socket file left by a crashed process
+	path := filepath.Join(dir, "grpc.sock")
+	stale, err := net.Listen("unix", path)
+	if !test.Nil(err) {
+		return
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	grpcSrv := microwave.NewGRPCServer(microwave.DefaultGRPCInterceptors(rec))
+	grpc_health_v1.RegisterHealthServer(grpcSrv, health.NewServer())
+
+	// injected listener
+	httpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !test.Nil(err) {
+		return
+	}
+	router := microwave.NewRouter()
+	router.HandleFunc(http.MethodGet, "/ping", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	})
+
+	mw.WaitGroup().Add(2)
+	go microwave.GRPCWrapper{Port: "unix://" + path, Server: grpcSrv}.Run(mw)
+	go microwave.HTTPWrapper{Listener: httpLis, Server: microwave.NewHTTPServer(router, nil)}.Run(mw)
+
+	conn, err := grpc.Dial("unix-socket",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		}),
+		grpc.WithInsecure(),
+	)
+	if !test.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if test.Nil(err) {
+		test.Equal(grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+	}
+
+	httpResp, err := http.Get("http://" + httpLis.Addr().String() + "/ping")
+	if test.Nil(err) {
+		body, _ := ioutil.ReadAll(httpResp.Body)
+		_ = httpResp.Body.Close()
+		test.Equal("pong", string(body))
+	}
+
+	// a socket in use is not replaced
+	mw.WaitGroup().Add(1)
+	go microwave.GRPCWrapper{Port: "unix://" + path, Server: grpc.NewServer()}.Run(mw)
+	select {
+	case err := <-mw.ErrorChan():
+		test.Equal(microwave.ErrUnixSocketInUse, errors.Cause(err))
+	case <-time.After(time.Second):
+		test.Fail("socket in use not reported")
+	}
+
+	// no panic on an empty port
+	mw.WaitGroup().Add(1)
+	go microwave.HTTPWrapper{Server: &http.Server{}}.Run(mw)
+	select {
+	case err := <-mw.ErrorChan():
+		test.Equal(microwave.ErrListenAddrMissing, err)
+	case <-time.After(time.Second):
+		test.Fail("empty port not reported")
+	}
+
+	mw.Stop()
+
+	rec.HasEntry(t, log.LevelInfo, "GRPC_SERVER_STOPPED", log.F("port", "unix://"+path))
+	rec.HasEntry(t, log.LevelInfo, "HTTP_SERVER_STOPPED", log.F("port", httpLis.Addr().String()))
+	_, err = os.Stat(path)
+	test.True(os.IsNotExist(err))
+}
@@ -0,0 +1,219 @@
+package microwave
+
+import (
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/sqrt-7/microwave/log"
+	"google.golang.org/grpc/grpclog"
+)
+
+const (
+	MsgGRPCLog = "GRPC_LOG"
+	MsgStdLog  = "STD_LOG"
+)
+
+// frames between a grpcLogger method & the call site
+const (
+	// grpclog.Infof
+	grpclogSkip = 1
+	// grpclog's internal InfoDepth, depth counts the frames above its caller
+	grpclogDepthSkip = 1
+)
+
+// RedirectLogs sends grpc-go internal logs (verbose logs up to grpcVerbosity) & the standard library
+// log package through logger, dropping entries below minLevel. It replaces process globals: call it
+// once at startup, before any gRPC use (grpclog.SetLoggerV2 isn't goroutine-safe). The returned func
+// restores the standard library log output & flags, grpclog has no getter & keeps logger
+func RedirectLogs(logger log.Logger, minLevel int, grpcVerbosity int) (restore func()) {
+	grpclog.SetLoggerV2(&grpcLogger{
+		logger:    logger,
+		minLevel:  minLevel,
+		verbosity: grpcVerbosity,
+	})
+
+	output, flags := stdlog.Writer(), stdlog.Flags()
+	stdlog.SetFlags(0) // the framework logger adds timestamps
+	stdlog.SetOutput(&stdLogWriter{
+		logger:   logger,
+		minLevel: minLevel,
+	})
+
+	return func() {
+		stdlog.SetOutput(output)
+		stdlog.SetFlags(flags)
+	}
+}
+
+// grpcLogger implements grpclog.LoggerV2 & grpclog.DepthLoggerV2
+type grpcLogger struct {
+	logger    log.Logger
+	minLevel  int
+	verbosity int
+}
+
+// send reports the caller skip frames above the caller of the grpcLogger method
+func (g *grpcLogger) send(level int, msg string, skip int) {
+	if level < g.minLevel {
+		return
+	}
+
+	entry := newLogEntry(g.logger, level, MsgGRPCLog).WithField("log", msg)
+	log.AddCallerSkip(entry, skip+2).Send()
+}
+
+func (g *grpcLogger) Info(args ...interface{}) {
+	g.send(log.LevelInfo, fmt.Sprint(args...), grpclogSkip)
+}
+
+func (g *grpcLogger) Infoln(args ...interface{}) {
+	g.send(log.LevelInfo, sprintln(args...), grpclogSkip)
+}
+
+func (g *grpcLogger) Infof(format string, args ...interface{}) {
+	g.send(log.LevelInfo, fmt.Sprintf(format, args...), grpclogSkip)
+}
+
+func (g *grpcLogger) Warning(args ...interface{}) {
+	g.send(log.LevelWarning, fmt.Sprint(args...), grpclogSkip)
+}
+
+func (g *grpcLogger) Warningln(args ...interface{}) {
+	g.send(log.LevelWarning, sprintln(args...), grpclogSkip)
+}
+
+func (g *grpcLogger) Warningf(format string, args ...interface{}) {
+	g.send(log.LevelWarning, fmt.Sprintf(format, args...), grpclogSkip)
+}
+
+func (g *grpcLogger) Error(args ...interface{}) {
+	g.send(log.LevelError, fmt.Sprint(args...), grpclogSkip)
+}
+
+func (g *grpcLogger) Errorln(args ...interface{}) {
+	g.send(log.LevelError, sprintln(args...), grpclogSkip)
+}
+
+func (g *grpcLogger) Errorf(format string, args ...interface{}) {
+	g.send(log.LevelError, fmt.Sprintf(format, args...), grpclogSkip)
+}
+
+// grpc passes the depth of the call site above grpclog's internal *Depth functions,
+// args are joined like the *ln fallback grpc uses without a DepthLoggerV2 ("[component] msg")
+func (g *grpcLogger) InfoDepth(depth int, args ...interface{}) {
+	g.send(log.LevelInfo, sprintln(args...), depth+grpclogDepthSkip)
+}
+
+func (g *grpcLogger) WarningDepth(depth int, args ...interface{}) {
+	g.send(log.LevelWarning, sprintln(args...), depth+grpclogDepthSkip)
+}
+
+func (g *grpcLogger) ErrorDepth(depth int, args ...interface{}) {
+	g.send(log.LevelError, sprintln(args...), depth+grpclogDepthSkip)
+}
+
+func (g *grpcLogger) FatalDepth(depth int, args ...interface{}) {
+	g.fatal(sprintln(args...), depth+grpclogDepthSkip)
+}
+
+func (g *grpcLogger) Fatal(args ...interface{}) {
+	g.fatal(fmt.Sprint(args...), grpclogSkip)
+}
+
+func (g *grpcLogger) Fatalln(args ...interface{}) {
+	g.fatal(sprintln(args...), grpclogSkip)
+}
+
+func (g *grpcLogger) Fatalf(format string, args ...interface{}) {
+	g.fatal(fmt.Sprintf(format, args...), grpclogSkip)
+}
+
+// V reports whether verbose (info) logs at level l are enabled
+func (g *grpcLogger) V(l int) bool {
+	return l <= g.verbosity
+}
+
+// fatal logs, flushes & exits as grpclog requires
+func (g *grpcLogger) fatal(msg string, skip int) {
+	entry := g.logger.Error(MsgGRPCLog).
+		WithField("log", msg).
+		WithField("fatal", true)
+	log.AddCallerSkip(entry, skip+2).Send()
+	if closer, ok := g.logger.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	os.Exit(1)
+}
+
+// stdLogWriter is the output of the standard library log package,
+// the level is taken from a leading "ERROR"/"[WARN]"/"debug:" style prefix (Info otherwise)
+type stdLogWriter struct {
+	logger   log.Logger
+	minLevel int
+}
+
+func (w *stdLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	level := stdLogLevel(msg)
+
+	if level >= w.minLevel {
+		entry := newLogEntry(w.logger, level, MsgStdLog).WithField("log", msg)
+		log.AddCallerSkip(entry, stdLogSkip()).Send()
+	}
+
+	return len(p), nil
+}
+
+// stdLogSkip returns the frames between Write & the caller of the log package (Logger.output, Printf, ...)
+func stdLogSkip() int {
+	var pcs [8]uintptr
+	// skip runtime.Callers, stdLogSkip & Write
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+
+	skip := 0
+	for {
+		frame, more := frames.Next()
+		skip++
+		if !strings.HasPrefix(frame.Function, "log.") || !more {
+			return skip
+		}
+	}
+}
+
+func stdLogLevel(msg string) int {
+	prefix := strings.ToLower(strings.TrimLeft(msg, "[ "))
+
+	switch {
+	case strings.HasPrefix(prefix, "error"), strings.HasPrefix(prefix, "fatal"), strings.HasPrefix(prefix, "panic"):
+		return log.LevelError
+	case strings.HasPrefix(prefix, "warn"):
+		return log.LevelWarning
+	case strings.HasPrefix(prefix, "debug"):
+		return log.LevelDebug
+	default:
+		return log.LevelInfo
+	}
+}
+
+func newLogEntry(logger log.Logger, level int, msg string) log.Entry {
+	switch level {
+	case log.LevelDebug:
+		return logger.Debug(msg)
+	case log.LevelWarning:
+		return logger.Warning(msg)
+	case log.LevelError:
+		return logger.Error(msg)
+	default:
+		return logger.Info(msg)
+	}
+}
+
+// sprintln is fmt.Sprintln without the trailing newline
+func sprintln(args ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
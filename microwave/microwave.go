@@ -1,8 +1,10 @@
 package microwave
 
 import (
+	"io"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -25,6 +27,14 @@ type Microwave struct {
 	envs      map[string]string
 	servers   []ServerWrapper
 
+	listeners        *listenerRegistry
+	inheritListeners bool
+	restartSignals   []os.Signal
+	restartParent    int // pid of the process which started this one on restart
+	restartErrCh     chan error
+
+	grpcDebug *grpcDebug
+
 	wg         *sync.WaitGroup
 	shutdownCh chan struct{}
 	errCh      chan error
@@ -36,12 +46,14 @@ func New(namespace string, options ...Option) (*Microwave, error) {
 	}
 
 	s := &Microwave{
-		namespace:  namespace,
-		envs:       make(map[string]string),
-		servers:    make([]ServerWrapper, 0),
-		wg:         &sync.WaitGroup{},
-		shutdownCh: make(chan struct{}),
-		errCh:      make(chan error),
+		namespace:    namespace,
+		envs:         make(map[string]string),
+		servers:      make([]ServerWrapper, 0),
+		listeners:    newListenerRegistry(),
+		restartErrCh: make(chan error, 1),
+		wg:           &sync.WaitGroup{},
+		shutdownCh:   make(chan struct{}),
+		errCh:        make(chan error),
 	}
 
 	for _, opt := range options {
@@ -59,6 +71,15 @@ func New(namespace string, options ...Option) (*Microwave, error) {
 		s.logger = logger
 	}
 
+	if s.inheritListeners {
+		if err := s.listeners.inherit(); err != nil {
+			return nil, errors.Wrap(err, MsgBootError)
+		}
+
+		s.restartParent, _ = strconv.Atoi(os.Getenv(envRestartParent))
+		_ = os.Unsetenv(envRestartParent)
+	}
+
 	return s, nil
 }
 
@@ -96,31 +117,69 @@ func (s *Microwave) Start() {
 
 	// Listen to system signals
 	osSig := make(chan os.Signal, 2)
-	signal.Notify(osSig, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(osSig, append([]os.Signal{os.Interrupt, syscall.SIGINT, syscall.SIGTERM}, s.restartSignals...)...)
 
 	go func() {
 		time.Sleep(time.Second * 3)
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 	}()
 
-	for _, srv := range s.servers {
+	servers := s.servers
+	if s.grpcDebug.enabled() {
+		debug, err := s.grpcDebug.wrapper(s)
+		if err != nil {
+			// reported by the main loop like the server errors, no server is started
+			s.logger.Error("GRPC_DEBUG_ERROR").WithError(err).Send()
+			servers = nil
+			go func() { s.errCh <- err }()
+		} else if debug != nil {
+			servers = append(servers, debug)
+		}
+	}
+
+	for _, srv := range servers {
 		s.wg.Add(1)
 		go srv.Run(s)
 	}
 
+	if s.restartParent != 0 {
+		go s.notifyParent()
+	}
+
+	restarting := false
+
 mainLoop:
 	for {
 		select {
 		case e := <-s.errCh:
 			{
-				s.logger.Error("SERVICE_ERROR").WithField("error", e.Error()).Send()
+				s.logger.Error("SERVICE_ERROR").WithError(e).Send()
 				break mainLoop
 			}
 		case sig := <-osSig:
 			{
+				if s.isRestartSignal(sig) {
+					// one restart at a time, this process is stopped by the new one
+					if !restarting {
+						if err := s.restart(); err != nil {
+							s.logger.Error("SERVICE_RESTART_ERROR").WithError(err).Send()
+						} else {
+							restarting = true
+						}
+					}
+					continue
+				}
+
 				s.logger.Info("SERVICE_TERM").WithField("sig", sig.String()).Send()
 				break mainLoop
 			}
+		case e := <-s.restartErrCh:
+			{
+				// keep serving, the listeners are ours again
+				s.logger.Error("SERVICE_RESTART_ERROR").WithError(e).Send()
+				s.listeners.setHandedOver(false)
+				restarting = false
+			}
 		}
 	}
 }
@@ -128,8 +187,38 @@ mainLoop:
 func (s *Microwave) Stop() {
 	close(s.shutdownCh)
 	s.wg.Wait()
+	s.listeners.closeUnclaimed()
+
+	// flush buffered log entries
+	if closer, ok := s.logger.(io.Closer); ok {
+		_ = closer.Close()
+	}
 }
 
-func (s *Microwave) AddServer(srv ServerWrapper) {
+// AddServer registers a server started by Start, the built-in wrappers' addresses are validated
+func (s *Microwave) AddServer(srv ServerWrapper) error {
+	if v, ok := srv.(serverValidator); ok {
+		if err := v.validate(); err != nil {
+			return err
+		}
+	}
+
 	s.servers = append(s.servers, srv)
+	return nil
+}
+
+func (s *Microwave) isRestartSignal(sig os.Signal) bool {
+	for _, restartSig := range s.restartSignals {
+		if sig == restartSig {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Microwave) debugConfig() *grpcDebug {
+	if s.grpcDebug == nil {
+		s.grpcDebug = &grpcDebug{}
+	}
+	return s.grpcDebug
 }
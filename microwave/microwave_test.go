@@ -1,12 +1,41 @@
 package microwave_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	stdlog "log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	grpc_tags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	"github.com/sqrt-7/microwave/log"
+	"github.com/sqrt-7/microwave/log/logtest"
 	"github.com/sqrt-7/microwave/microwave"
+	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/stats/view"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 type TestWrapper struct {
@@ -47,13 +76,18 @@ func TestService1(t *testing.T) {
 		Server: grpcSrv,
 	}
 
-	httpSrv := microwave.NewHTTPServer()
+	router := microwave.NewRouter()
+	router.HandleFunc(http.MethodGet, "/ping", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	})
+
+	httpSrv := microwave.NewHTTPServer(router, microwave.DefaultHTTPMiddlewares(mw.Logger()))
 	httpWrapper := &microwave.HTTPWrapper{
 		Port:   mw.Env("HTTP_PORT"),
 		Server: httpSrv,
 	}
 
-	anotherHttpSrv := microwave.NewHTTPServer()
+	anotherHttpSrv := microwave.NewHTTPServer(nil, nil)
 	anotherHttpWrapper := &microwave.HTTPWrapper{
 		Port:   mw.Env("ANOTHER_HTTP_PORT"),
 		Server: anotherHttpSrv,
@@ -65,3 +99,557 @@ func TestService1(t *testing.T) {
 
 	mw.Start()
 }
+
+// envRedirectLogs runs TestRedirectLogs in a new process, the redirect is installed before any gRPC use
+const envRedirectLogs = "MICROWAVE_TEST_REDIRECT_LOGS"
+
+func TestRedirectLogs(t *testing.T) {
+	test := assert.New(t)
+
+	if os.Getenv(envRedirectLogs) == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestRedirectLogs$", "-test.v")
+		cmd.Env = append(os.Environ(), envRedirectLogs+"=1")
+		out, err := cmd.CombinedOutput()
+		test.Nil(err, string(out))
+		test.Contains(string(out), "--- PASS: TestRedirectLogs")
+		return
+	}
+
+	namespace := "my-service"
+	rec := logtest.New(namespace)
+
+	output, flags := stdlog.Writer(), stdlog.Flags()
+	restore := microwave.RedirectLogs(rec, log.LevelInfo, 1)
+
+	grpclog.Infof("[core] channel %d created", 1)
+	grpclog.Warningln("transport", "closing")
+	test.True(grpclog.V(1))
+	test.False(grpclog.V(2))
+
+	stdlog.Print("plain message")
+	stdlog.Printf("[WARN] disk usage %d%%", 91)
+	stdlog.Print("debug: dropped below min level")
+
+	rec.HasEntry(t, log.LevelInfo, microwave.MsgGRPCLog, log.F("log", "[core] channel 1 created"), log.F("namespace", namespace))
+	rec.HasEntry(t, log.LevelWarning, microwave.MsgGRPCLog, log.F("log", "transport closing"))
+	rec.HasEntry(t, log.LevelInfo, microwave.MsgStdLog, log.F("log", "plain message"))
+	rec.HasEntry(t, log.LevelWarning, microwave.MsgStdLog, log.F("log", "[WARN] disk usage 91%"))
+	test.Equal(0, rec.Entries().Level(log.LevelDebug).Len())
+
+	// exactly these, in order
+	grpcLogs := rec.Entries().Message(microwave.MsgGRPCLog)
+	if test.Len(grpcLogs, 2) {
+		test.Equal(log.LevelInfo, grpcLogs[0].Level)
+		test.Equal("[core] channel 1 created", grpcLogs[0].Fields["log"])
+		test.Equal(log.LevelWarning, grpcLogs[1].Level)
+		test.Equal("transport closing", grpcLogs[1].Fields["log"])
+	}
+
+	stdLogs := rec.Entries().Message(microwave.MsgStdLog)
+	if test.Len(stdLogs, 2) {
+		test.Equal(log.LevelInfo, stdLogs[0].Level)
+		test.Equal("plain message", stdLogs[0].Fields["log"])
+		test.Equal(log.LevelWarning, stdLogs[1].Level)
+		test.Equal("[WARN] disk usage 91%", stdLogs[1].Fields["log"])
+	}
+
+	// component loggers go through the grpclog.DepthLoggerV2 methods
+	grpclog.Component("transport").Errorf("closing %d", 2)
+	rec.HasEntry(t, log.LevelError, microwave.MsgGRPCLog, log.F("log", "[transport] closing 2"))
+
+	// every entry reports the line in this test, not the adapters
+	for _, e := range rec.Entries() {
+		test.Equal("microwave_test.go", filepath.Base(e.Caller.File), e.Fields["log"])
+		test.Equal("github.com/sqrt-7/microwave/microwave_test.TestRedirectLogs", e.Caller.Function, e.Fields["log"])
+	}
+
+	restore()
+	test.Equal(output, stdlog.Writer())
+	test.Equal(flags, stdlog.Flags())
+}
+
+func TestTraceParent(t *testing.T) {
+	test := assert.New(t)
+
+	namespace := "my-service"
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	rec := logtest.New(namespace)
+
+	// gRPC: the server span continues the caller's trace
+	lis := bufconn.Listen(1024 * 1024)
+	srv := microwave.NewGRPCServer(microwave.DefaultGRPCInterceptors(rec))
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if !test.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), log.TraceParentHeader, traceParent)
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	test.Nil(err)
+
+	rec.HasEntry(t, log.LevelInfo, "GRPC_IN", log.F("TraceID", traceID))
+
+	// HTTP: entries are correlated with the caller's span
+	handler := microwave.HTTPLogContext(rec)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.FromContext(r.Context()).Info("HTTP_HANDLER").For(r.Context()).Send()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(log.TraceParentHeader, traceParent)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec.HasEntry(t, log.LevelInfo, "HTTP_HANDLER", log.F("TraceID", traceID), log.F("SpanID", "00f067aa0ba902b7"))
+}
+
+func TestHTTPMiddlewares(t *testing.T) {
+	test := assert.New(t)
+
+	namespace := "my-service"
+	rec := logtest.New(namespace)
+
+	router := microwave.NewRouter()
+	router.HandleFunc(http.MethodGet, "/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		log.FromContext(r.Context()).Info("HTTP_HANDLER").For(r.Context()).WithField("id", microwave.PathParam(r, "id")).Send()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	router.HandleFunc(http.MethodGet, "/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	srv := microwave.NewHTTPServer(router, microwave.DefaultHTTPMiddlewares(rec))
+
+	// request ID & trace are propagated
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	req.Header.Set(log.TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(resp, req)
+
+	test.Equal(http.StatusAccepted, resp.Code)
+	requestID := resp.Header().Get("X-Request-Id")
+	test.Len(requestID, 32)
+
+	traceID := log.F("TraceID", "4bf92f3577b34da6a3ce929d0e0e4736")
+	rec.HasEntry(t, log.LevelInfo, "HTTP_IN", log.F("method", http.MethodGet), log.F("path", "/users/42"), log.F("RequestID", requestID), traceID)
+	rec.HasEntry(t, log.LevelInfo, "HTTP_HANDLER", log.F("id", "42"), log.F("RequestID", requestID), traceID)
+	rec.HasEntry(t, log.LevelInfo, "HTTP_OUT", log.F("status", http.StatusAccepted), log.F("RequestID", requestID), traceID)
+
+	// existing request IDs are kept
+	req = httptest.NewRequest(http.MethodGet, "/missing", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	resp = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(resp, req)
+
+	test.Equal(http.StatusNotFound, resp.Code)
+	test.Equal("req-1", resp.Header().Get("X-Request-Id"))
+	rec.HasEntry(t, log.LevelInfo, "HTTP_OUT", log.F("status", http.StatusNotFound), log.F("RequestID", "req-1"))
+
+	// panics are recovered
+	resp = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	test.Equal(http.StatusInternalServerError, resp.Code)
+	rec.HasEntry(t, log.LevelError, "HTTP_PANIC", log.F("panic", "boom"))
+	rec.HasEntry(t, log.LevelInfo, "HTTP_OUT", log.F("path", "/panic"), log.F("status", http.StatusInternalServerError))
+}
+
+func TestGRPCContextFields(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+	unary, stream := microwave.DefaultGRPCInterceptors(rec)
+
+	// tags set before the context interceptor are copied to the log fields, later ones aren't
+	earlyTag := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		grpc_tags.Extract(ctx).Set("route", "early")
+		return handler(ctx, req)
+	}
+	unary = append([]grpc.UnaryServerInterceptor{unary[0], earlyTag}, unary[1:]...)
+
+	unaryHandler := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		grpc_tags.Extract(ctx).Set("late", "handler")
+		log.FromContext(ctx).Info("GRPC_HANDLER").For(ctx).Send()
+		return handler(ctx, req)
+	}
+	streamHandler := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		grpc_tags.Extract(ss.Context()).Set("late", "handler")
+		log.FromContext(ss.Context()).Info("GRPC_STREAM_HANDLER").For(ss.Context()).Send()
+		return handler(srv, ss)
+	}
+
+	srv := microwave.NewGRPCServer(append(unary, unaryHandler), append(stream, streamHandler))
+	testpb.RegisterTestServiceServer(srv, &testServer{})
+
+	lis := bufconn.Listen(1024 * 1024)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if !test.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-1", "x-user-id", "user-1", "x-tenant-id", "tenant-1")
+	fields := []log.Field{log.F("RequestID", "req-1"), log.F("UserID", "user-1"), log.F("TenantID", "tenant-1")}
+
+	client := testpb.NewTestServiceClient(conn)
+	_, err = client.UnaryCall(ctx, &testpb.SimpleRequest{})
+	test.Nil(err)
+
+	rec.HasEntry(t, log.LevelInfo, "GRPC_IN", append(fields, log.F("route", "early"))...)
+	rec.HasEntry(t, log.LevelInfo, "GRPC_HANDLER", append(fields, log.F("route", "early"))...)
+	test.Equal(0, rec.Entries().Field("late").Len())
+
+	out, err := client.StreamingOutputCall(ctx, &testpb.StreamingOutputCallRequest{})
+	if test.Nil(err) {
+		_, err = out.Recv()
+		test.Equal(io.EOF, err)
+	}
+
+	rec.HasEntry(t, log.LevelInfo, "GRPC_STREAM_IN", fields...)
+	rec.HasEntry(t, log.LevelInfo, "GRPC_STREAM_HANDLER", fields...)
+	test.Equal(0, rec.Entries().Field("late").Len())
+}
+
+func TestHTTPLogContext(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+	handler := microwave.HTTPLogContext(rec)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.FromContext(r.Context()).Info("HTTP_HANDLER").For(r.Context()).Send()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	req.Header.Set("X-User-Id", "user-1")
+	req.Header.Set("X-Tenant-Id", "tenant-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec.HasEntry(t, log.LevelInfo, "HTTP_HANDLER", log.F("RequestID", "req-1"), log.F("UserID", "user-1"), log.F("TenantID", "tenant-1"))
+
+	// missing headers add no fields
+	rec.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if test.Equal(1, rec.Count(log.LevelInfo, "HTTP_HANDLER")) {
+		test.Equal(0, rec.Entries().Field("RequestID").Len())
+		test.Equal(0, rec.Entries().Field("UserID").Len())
+	}
+}
+
+func TestHTTPRecovery(t *testing.T) {
+	test := assert.New(t)
+
+	if !test.Nil(view.Register(microwave.HTTPPanicsView)) {
+		return
+	}
+	defer view.Unregister(microwave.HTTPPanicsView)
+
+	rec := logtest.New("my-service")
+	handler := microwave.HTTPRecovery(rec)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/abort":
+			panic(http.ErrAbortHandler)
+		case "/partial":
+			_, _ = w.Write([]byte("partial"))
+		}
+		panic(errors.New("db password=secret"))
+	}))
+
+	// JSON 500 without the panic value
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/panic", nil))
+
+	test.Equal(http.StatusInternalServerError, resp.Code)
+	test.Equal("application/json", resp.Header().Get("Content-Type"))
+	test.JSONEq(`{"code":500,"message":"Internal Server Error"}`, resp.Body.String())
+	rec.HasEntry(t, log.LevelError, "HTTP_PANIC", log.F("panic", "db password=secret"), log.F("method", http.MethodPost), log.F("path", "/panic"))
+	test.NotEmpty(rec.Entries().Message("HTTP_PANIC")[0].Fields["stack"])
+
+	// http.ErrAbortHandler is passed on to net/http & not logged
+	test.PanicsWithValue(http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+	})
+	test.Equal(1, rec.Count(log.LevelError, "HTTP_PANIC"))
+
+	// the response can't be replaced once started
+	resp = httptest.NewRecorder()
+	test.PanicsWithValue(http.ErrAbortHandler, func() {
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/partial", nil))
+	})
+	test.Equal("partial", resp.Body.String())
+	test.Equal(2, rec.Count(log.LevelError, "HTTP_PANIC"))
+
+	rows, err := view.RetrieveData(microwave.HTTPPanicsView.Name)
+	if test.Nil(err) && test.Len(rows, 2) {
+		counts := map[string]int64{}
+		for _, row := range rows {
+			counts[row.Tags[0].Value] = row.Data.(*view.CountData).Value
+		}
+		test.Equal(map[string]int64{http.MethodPost: 1, http.MethodGet: 1}, counts)
+	}
+}
+
+func TestHTTPHijack(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+	router := microwave.NewRouter()
+	router.HandleFunc(http.MethodGet, "/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+
+		line, _ := rw.ReadString('\n')
+		_, _ = rw.WriteString(line)
+		_ = rw.Flush()
+	})
+
+	srv := httptest.NewServer(microwave.NewHTTPServer(router, microwave.DefaultHTTPMiddlewares(rec)).Handler)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if !test.Nil(err) {
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	_, _ = fmt.Fprint(conn, "GET /upgrade HTTP/1.1\r\nHost: test\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if !test.Nil(err) {
+		return
+	}
+	test.Equal(http.StatusSwitchingProtocols, resp.StatusCode)
+
+	_, _ = fmt.Fprint(conn, "ping\n")
+	line, err := r.ReadString('\n')
+	test.Nil(err)
+	test.Equal("ping\n", line)
+
+	test.Eventually(func() bool {
+		return rec.Count(log.LevelInfo, "HTTP_OUT") == 1
+	}, time.Second, 10*time.Millisecond)
+	rec.HasEntry(t, log.LevelInfo, "HTTP_OUT", log.F("path", "/upgrade"), log.F("status", http.StatusSwitchingProtocols))
+}
+
+func TestMuxWrapper(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+	mw, err := microwave.New("my-service", microwave.CustomLogger(rec))
+	if !test.Nil(err) {
+		return
+	}
+
+	// free port
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !test.Nil(err) {
+		return
+	}
+	port := fmt.Sprint(lis.Addr().(*net.TCPAddr).Port)
+	_ = lis.Close()
+
+	grpcSrv := microwave.NewGRPCServer(microwave.DefaultGRPCInterceptors(rec))
+	grpc_health_v1.RegisterHealthServer(grpcSrv, health.NewServer())
+
+	started, release := make(chan struct{}), make(chan struct{})
+	router := microwave.NewRouter()
+	router.HandleFunc(http.MethodGet, "/ping", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong " + r.Proto))
+	})
+	router.HandleFunc(http.MethodGet, "/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})
+
+	httpSrv := microwave.NewHTTPServer(router, microwave.DefaultHTTPMiddlewares(rec))
+	mw.WaitGroup().Add(1)
+	go microwave.MuxWrapper{
+		Port:       port,
+		GRPCServer: grpcSrv,
+		HTTPServer: httpSrv,
+	}.Run(mw)
+
+	addr := "127.0.0.1:" + port
+	test.Eventually(func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// gRPC
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if !test.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if test.Nil(err) {
+		test.Equal(grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+	}
+
+	// clients waiting for the server SETTINGS before sending HEADERS
+	grpcStatus, err := grpcAfterSettings(addr)
+	if test.Nil(err) {
+		test.Equal("0", grpcStatus)
+	}
+
+	// HTTP/1.1 & h2c
+	var dials int32
+	h2c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return net.Dial(network, addr)
+		},
+	}}
+	for client, expected := range map[*http.Client]string{http.DefaultClient: "pong HTTP/1.1", h2c: "pong HTTP/2.0"} {
+		httpResp, err := client.Get("http://" + addr + "/ping")
+		if test.Nil(err) {
+			body, _ := ioutil.ReadAll(httpResp.Body)
+			_ = httpResp.Body.Close()
+			test.Equal(expected, string(body))
+		}
+	}
+
+	// the h2c connection is reused
+	for i := 0; i < 3; i++ {
+		httpResp, err := h2c.Get("http://" + addr + "/ping")
+		if test.Nil(err) {
+			body, _ := ioutil.ReadAll(httpResp.Body)
+			_ = httpResp.Body.Close()
+			test.Equal("pong HTTP/2.0", string(body))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(int32(1), atomic.LoadInt32(&dials))
+
+	rec.HasEntry(t, log.LevelInfo, "GRPC_IN", log.F("method", "/grpc.health.v1.Health/Check"))
+	rec.HasEntry(t, log.LevelInfo, "HTTP_OUT", log.F("path", "/ping"), log.F("status", http.StatusOK))
+
+	// requests in flight complete on Stop
+	slow := make(chan string)
+	go func() {
+		httpResp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		body, _ := ioutil.ReadAll(httpResp.Body)
+		_ = httpResp.Body.Close()
+		slow <- string(body)
+	}()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		mw.Stop()
+		close(stopped)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	test.Equal("done", <-slow)
+	<-stopped
+
+	rec.HasEntry(t, log.LevelInfo, "MUX_SERVER_STOPPED", log.F("port", ":"+port))
+	_, err = net.Dial("tcp", addr)
+	test.NotNil(err)
+
+	// the h2c handler wraps a copy of the server
+	test.IsType(http.HandlerFunc(nil), httpSrv.Handler)
+}
+
+// grpcAfterSettings calls the health service like clients sending HEADERS after reading the server
+// SETTINGS (e.g. grpc-java), the headers are larger than the first read, & returns the grpc-status
+func grpcAfterSettings(addr string) (string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte(http2.ClientPreface)); err != nil {
+		return "", err
+	}
+	framer := http2.NewFramer(conn, conn)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	if err := framer.WriteSettings(); err != nil {
+		return "", err
+	}
+	if f, err := framer.ReadFrame(); err != nil {
+		return "", err
+	} else if _, ok := f.(*http2.SettingsFrame); !ok {
+		return "", fmt.Errorf("unexpected frame %v", f)
+	}
+
+	block := &bytes.Buffer{}
+	enc := hpack.NewEncoder(block)
+	for _, hf := range []hpack.HeaderField{
+		{Name: ":method", Value: http.MethodPost},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/grpc.health.v1.Health/Check"},
+		{Name: ":authority", Value: addr},
+		{Name: "content-type", Value: "application/grpc"},
+		{Name: "te", Value: "trailers"},
+		{Name: "x-padding", Value: strings.Repeat("x", 1000)},
+	} {
+		_ = enc.WriteField(hf)
+	}
+	if err := framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: block.Bytes(), EndHeaders: true}); err != nil {
+		return "", err
+	}
+	if err := framer.WriteData(1, true, []byte{0, 0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+	if err := framer.WriteSettingsAck(); err != nil {
+		return "", err
+	}
+
+	for i := 0; i < 20; i++ {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			return "", err
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				_ = framer.WriteSettingsAck()
+			}
+		case *http2.MetaHeadersFrame:
+			for _, hf := range f.Fields {
+				if hf.Name == "grpc-status" {
+					return hf.Value, nil
+				}
+			}
+		case *http2.GoAwayFrame:
+			return "", fmt.Errorf("go away %v", f.ErrCode)
+		}
+	}
+	return "", errors.New("grpc-status missing")
+}
@@ -1,9 +1,14 @@
 package microwave
 
 import (
+	"context"
+	"os"
+	"syscall"
+
 	"github.com/pkg/errors"
 	"github.com/sqrt-7/microwave/log"
 	"github.com/sqrt-7/microwave/tools"
+	"google.golang.org/grpc"
 )
 
 type Option interface {
@@ -36,3 +41,72 @@ func RequireEnvs(envs ...string) Option {
 		return nil
 	})
 }
+
+// InheritListeners serves the wrappers on the listeners passed by systemd socket activation
+// (LISTEN_FDS) or by a restarting parent process, matched by address. Wrappers without
+// an inherited listener listen as usual
+func InheritListeners() Option {
+	return optionFn(func(input *Microwave) error {
+		input.inheritListeners = true
+		return nil
+	})
+}
+
+// GracefulRestart starts a new process of the binary on the signals (SIGHUP & SIGUSR2 by default),
+// handing over the listeners. This process drains & stops once the new one serves them,
+// the new process inherits the listeners (see InheritListeners)
+func GracefulRestart(signals ...os.Signal) Option {
+	return optionFn(func(input *Microwave) error {
+		if len(signals) == 0 {
+			signals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
+		}
+
+		input.inheritListeners = true
+		input.restartSignals = signals
+		return nil
+	})
+}
+
+// GRPCReflection serves the gRPC reflection service (used by grpcurl & similar tools) on the gRPC servers
+// of the wrappers, listing their services. The services are registered on Start, which fails
+// without gRPC server (e.g. a GRPCWrapper or MuxWrapper) or admin port
+func GRPCReflection() Option {
+	return optionFn(func(input *Microwave) error {
+		input.debugConfig().reflection = true
+		return nil
+	})
+}
+
+// GRPCChannelz serves the channelz service on the gRPC servers of the wrappers, reporting
+// the channels, servers & sockets of the process. The services are registered on Start (see GRPCReflection)
+func GRPCChannelz() Option {
+	return optionFn(func(input *Microwave) error {
+		input.debugConfig().channelz = true
+		return nil
+	})
+}
+
+// GRPCAdminPort serves reflection & channelz only on port (a port, host:port or unix:///path.sock)
+// with the default interceptors & options (e.g. TLS credentials), instead of the gRPC servers of the wrappers.
+// Reflection there only lists the services served on the port
+func GRPCAdminPort(port string, options ...grpc.ServerOption) Option {
+	return optionFn(func(input *Microwave) error {
+		if _, err := parseListenAddr(port); err != nil {
+			return err
+		}
+
+		input.debugConfig().adminPort = port
+		input.debugConfig().adminOptions = options
+		return nil
+	})
+}
+
+// GRPCDebugAuth checks the reflection & channelz calls on the admin port (required, see GRPCAdminPort),
+// the call fails with the returned error (errors without a gRPC status are reported as PermissionDenied).
+// See PeerIdentityFromContext for mTLS
+func GRPCDebugAuth(auth func(ctx context.Context) error) Option {
+	return optionFn(func(input *Microwave) error {
+		input.debugConfig().auth = auth
+		return nil
+	})
+}
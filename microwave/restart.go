@@ -0,0 +1,294 @@
+package microwave
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// systemd socket activation protocol (sd_listen_fds), also used to pass listeners on restart
+	envListenFDs     = "LISTEN_FDS"
+	envListenPID     = "LISTEN_PID"
+	envListenFDNames = "LISTEN_FDNAMES"
+	listenFDsStart   = 3
+
+	// pid of the process stopped once the restarted process serves the listeners
+	envRestartParent = "MICROWAVE_RESTART_PARENT"
+
+	// time given to a restarted process to serve every inherited listener
+	restartReadyTimeout = 30 * time.Second
+)
+
+var (
+	ErrListenFDsInvalid = errors.New("LISTEN_FDS invalid")
+)
+
+// listenerRegistry keeps the listeners inherited from systemd or a parent process until
+// the wrappers claim them, & the served listeners to hand them over on restart
+type listenerRegistry struct {
+	mu         sync.Mutex
+	inherited  map[string]net.Listener
+	served     []net.Listener
+	handedOver bool
+
+	claimed     chan struct{} // closed once every inherited listener is claimed
+	claimedDone bool
+}
+
+func newListenerRegistry() *listenerRegistry {
+	return &listenerRegistry{
+		inherited: make(map[string]net.Listener),
+		claimed:   make(chan struct{}),
+	}
+}
+
+// inherit takes the listeners passed with the LISTEN_FDS protocol (from fd 3), the
+// variables are unset so the processes started by this one don't inherit them
+func (r *listenerRegistry) inherit() error {
+	fds, pid := os.Getenv(envListenFDs), os.Getenv(envListenPID)
+	for _, env := range []string{envListenFDs, envListenPID, envListenFDNames} {
+		_ = os.Unsetenv(env)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.checkClaimed()
+
+	// LISTEN_PID is set by systemd, a parent process can't know the pid before starting it
+	if fds == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return errors.Wrap(ErrListenFDsInvalid, fds)
+	}
+
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("listen-fd-%d", fd))
+		lis, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			return errors.Wrapf(err, "inherited fd %d", fd)
+		}
+
+		r.inherited[addrKey(lis.Addr())] = lis
+	}
+
+	return nil
+}
+
+// listen returns the inherited listener for port, or the injected one, or listens on port
+func (r *listenerRegistry) listen(port string, lis net.Listener) (net.Listener, string, func(), error) {
+	served, addr, cleanup, err := r.claim(port, lis)
+	if served == nil && err == nil {
+		served, addr, cleanup, err = serverListener(port, lis)
+	}
+	if err != nil {
+		return nil, "", func() {}, err
+	}
+
+	r.mu.Lock()
+	r.served = append(r.served, served)
+	r.mu.Unlock()
+
+	// the socket file is used by the restarted process
+	return served, addr, func() {
+		r.mu.Lock()
+		handedOver := r.handedOver
+		r.mu.Unlock()
+		if !handedOver {
+			cleanup()
+		}
+	}, nil
+}
+
+// claim returns the inherited listener matching port, if any
+func (r *listenerRegistry) claim(port string, lis net.Listener) (net.Listener, string, func(), error) {
+	if lis != nil || validateListen(port, lis) != nil {
+		return nil, "", nil, nil
+	}
+
+	addr, _ := parseListenAddr(port)
+	key, err := listenAddrKey(addr)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.checkClaimed()
+
+	inherited, ok := r.inherited[key]
+	if !ok {
+		return nil, "", nil, nil
+	}
+	delete(r.inherited, key)
+
+	return inherited, addr.String(), socketCleanup(addr), nil
+}
+
+func (r *listenerRegistry) checkClaimed() {
+	if len(r.inherited) == 0 && !r.claimedDone {
+		r.claimedDone = true
+		close(r.claimed)
+	}
+}
+
+// files duplicates the descriptors of the served listeners for a new process
+func (r *listenerRegistry) files() ([]*os.File, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var files []*os.File
+	for _, lis := range r.served {
+		f, ok := lis.(interface{ File() (*os.File, error) })
+		if !ok {
+			// e.g. in-memory listeners
+			continue
+		}
+
+		file, err := f.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, errors.Wrapf(err, "listener %s", lis.Addr())
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// setHandedOver keeps the unix socket files for the restarted process
+func (r *listenerRegistry) setHandedOver(handedOver bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handedOver = handedOver
+	for _, lis := range r.served {
+		if unix, ok := lis.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(!handedOver)
+		}
+	}
+}
+
+func (r *listenerRegistry) isHandedOver() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.handedOver
+}
+
+// closeUnclaimed closes the inherited listeners no wrapper serves
+func (r *listenerRegistry) closeUnclaimed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, lis := range r.inherited {
+		_ = lis.Close()
+		delete(r.inherited, key)
+	}
+	r.checkClaimed()
+}
+
+// restart starts a new process of the binary with the served listeners,
+// this process is stopped by the new one once it serves them (see notifyParent)
+func (s *Microwave) restart() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "executable")
+	}
+
+	files, err := s.listeners.files()
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%d", envListenFDs, len(files)),
+		fmt.Sprintf("%s=%d", envRestartParent, os.Getpid()),
+	)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+
+	s.listeners.setHandedOver(true)
+	if err := cmd.Start(); err != nil {
+		s.listeners.setHandedOver(false)
+		return errors.Wrap(err, "start process")
+	}
+
+	s.logger.Info("SERVICE_RESTART").WithField("pid", cmd.Process.Pid).WithField("listeners", len(files)).Send()
+
+	go func() {
+		// only reported while this process is serving (the new one failed)
+		err := cmd.Wait()
+		s.restartErrCh <- errors.Errorf("restarted process %d exited: %v", cmd.Process.Pid, err)
+	}()
+
+	return nil
+}
+
+// notifyParent stops the process which started this one once the inherited listeners are served
+func (s *Microwave) notifyParent() {
+	select {
+	case <-s.listeners.claimed:
+	case <-time.After(restartReadyTimeout):
+		s.logger.Warning("SERVICE_RESTART_UNCLAIMED_LISTENERS").Send()
+	case <-s.shutdownCh:
+		return
+	}
+
+	s.logger.Info("SERVICE_RESTARTED").WithField("parent", s.restartParent).Send()
+	if err := syscall.Kill(s.restartParent, syscall.SIGTERM); err != nil {
+		s.logger.Error("SERVICE_RESTART_ERROR").WithError(err).Send()
+	}
+}
+
+// addrKey identifies a listening address, listenAddrKey gives the same key for the wrapper address
+func addrKey(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return tcpKey(a.IP, a.Port)
+	case *net.UnixAddr:
+		return "unix:" + a.Name
+	default:
+		return addr.Network() + ":" + addr.String()
+	}
+}
+
+func listenAddrKey(addr listenAddr) (string, error) {
+	if addr.network == "unix" {
+		return "unix:" + addr.address, nil
+	}
+
+	tcp, err := net.ResolveTCPAddr(addr.network, addr.address)
+	if err != nil {
+		return "", errors.Wrap(err, "resolve listen address")
+	}
+	return tcpKey(tcp.IP, tcp.Port), nil
+}
+
+// all interfaces match whatever the IP version
+func tcpKey(ip net.IP, port int) string {
+	if ip == nil || ip.IsUnspecified() {
+		return fmt.Sprintf("tcp::%d", port)
+	}
+	return fmt.Sprintf("tcp:%s:%d", ip, port)
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
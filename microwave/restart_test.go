@@ -0,0 +1,236 @@
+package microwave_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sqrt-7/microwave/log/logtest"
+	"github.com/sqrt-7/microwave/microwave"
+	"github.com/stretchr/testify/assert"
+)
+
+// address served by TestRestartHelper
+const envRestartHelperAddr = "MICROWAVE_TEST_RESTART_ADDR"
+
+// TestRestartHelper is the service run in a child process by the restart tests
+func TestRestartHelper(t *testing.T) {
+	addr := os.Getenv(envRestartHelperAddr)
+	if addr == "" {
+		t.Skip("run by TestInheritListeners & TestGracefulRestart")
+	}
+
+	mw, err := microwave.New("my-service", microwave.GracefulRestart())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := microwave.NewRouter()
+	router.HandleFunc(http.MethodGet, "/pid", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, os.Getpid())
+	})
+	if err := mw.AddServer(microwave.HTTPWrapper{Port: addr, Server: microwave.NewHTTPServer(router, nil)}); err != nil {
+		t.Fatal(err)
+	}
+
+	mw.Start()
+}
+
+func restartHelper(addr string, files ...*os.File) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRestartHelper$")
+	cmd.Env = append(os.Environ(), envRestartHelperAddr+"="+addr)
+	cmd.ExtraFiles = files
+	if len(files) > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("LISTEN_FDS=%d", len(files)))
+	}
+	return cmd
+}
+
+// getPID returns the pid of the process serving the request, on a new connection
+func getPID(addr string) (string, error) {
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: time.Second}
+	resp, err := client.Get("http://" + addr + "/pid")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func waitExit(cmd *exec.Cmd, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		_ = cmd.Process.Kill()
+		return fmt.Errorf("process %d still running", cmd.Process.Pid)
+	}
+}
+
+func TestInheritListeners(t *testing.T) {
+	test := assert.New(t)
+
+	// the listener stays open here, the child can't listen on the address itself
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !test.Nil(err) {
+		return
+	}
+	defer lis.Close()
+
+	file, err := lis.(*net.TCPListener).File()
+	if !test.Nil(err) {
+		return
+	}
+	defer file.Close()
+
+	addr := lis.Addr().String()
+	cmd := restartHelper(addr, file)
+	if !test.Nil(cmd.Start()) {
+		return
+	}
+
+	var pid string
+	test.Eventually(func() bool {
+		pid, err = getPID(addr)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	test.Equal(fmt.Sprint(cmd.Process.Pid), pid)
+
+	_ = cmd.Process.Signal(syscall.SIGTERM)
+	test.Nil(waitExit(cmd, 2*time.Second))
+}
+
+func TestGracefulRestart(t *testing.T) {
+	test := assert.New(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !test.Nil(err) {
+		return
+	}
+	addr := lis.Addr().String()
+	_ = lis.Close()
+
+	parent := restartHelper(addr)
+	if !test.Nil(parent.Start()) {
+		return
+	}
+
+	var parentPID string
+	test.Eventually(func() bool {
+		parentPID, err = getPID(addr)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	test.Equal(fmt.Sprint(parent.Process.Pid), parentPID)
+
+	// requests on new connections during the restart all succeed
+	var (
+		mu     sync.Mutex
+		errs   []error
+		pids   = map[string]bool{}
+		stop   = make(chan struct{})
+		looped = make(chan struct{})
+	)
+	go func() {
+		defer close(looped)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+
+			pid, err := getPID(addr)
+			mu.Lock()
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				pids[pid] = true
+			}
+			mu.Unlock()
+		}
+	}()
+
+	_ = parent.Process.Signal(syscall.SIGHUP)
+
+	// stopped by the new process once it serves the listener
+	test.Nil(waitExit(parent, 2*time.Second))
+
+	childPID, err := getPID(addr)
+	close(stop)
+	<-looped
+
+	if test.Nil(err) {
+		test.NotEqual(parentPID, childPID)
+	}
+
+	mu.Lock()
+	test.Empty(errs)
+	test.False(pids[""])
+	mu.Unlock()
+
+	pid := 0
+	_, _ = fmt.Sscan(childPID, &pid)
+	if pid > 0 {
+		_ = syscall.Kill(pid, syscall.SIGTERM)
+	}
+	test.Eventually(func() bool {
+		_, err := getPID(addr)
+		return err != nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// wrappedListener hides the listener's SetDeadline
+type wrappedListener struct {
+	net.Listener
+}
+
+func TestHTTPStopWithoutRestart(t *testing.T) {
+	test := assert.New(t)
+
+	mw, err := microwave.New("my-service", microwave.CustomLogger(logtest.New("my-service")))
+	if !test.Nil(err) {
+		return
+	}
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if !test.Nil(err) {
+		return
+	}
+
+	// the wrapper serves a copy, the server's ConnState hook is left as is
+	srv := microwave.NewHTTPServer(nil, nil)
+	connState := func(net.Conn, http.ConnState) {}
+	srv.ConnState = connState
+
+	mw.WaitGroup().Add(1)
+	go microwave.HTTPWrapper{Listener: wrappedListener{tcp}, Server: srv}.Run(mw)
+
+	// served
+	test.Eventually(func() bool {
+		resp, err := http.Get("http://" + tcp.Addr().String())
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// only handed over listeners are drained
+	start := time.Now()
+	mw.Stop()
+	test.Less(int64(time.Since(start)), int64(time.Second))
+
+	test.Equal(reflect.ValueOf(connState).Pointer(), reflect.ValueOf(srv.ConnState).Pointer())
+}
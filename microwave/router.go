@@ -0,0 +1,148 @@
+package microwave
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type ctxPathParamsKey struct{}
+
+// Router matches requests by method & path pattern, pattern segments like "{id}"
+// are path parameters (read with PathParam). Static segments win over parameters,
+// on a tie the route with the later first parameter wins. GET routes also serve HEAD
+type Router struct {
+	routes []*route
+
+	NotFound http.Handler // default http.NotFoundHandler
+}
+
+type route struct {
+	method   string // empty matches any method
+	pattern  string
+	segments []string
+	handler  http.Handler
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers the handler for the method ("" for any) & path pattern
+func (r *Router) Handle(method string, pattern string, handler http.Handler) {
+	r.routes = append(r.routes, &route{
+		method:   strings.ToUpper(method),
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handler:  handler,
+	})
+}
+
+func (r *Router) HandleFunc(method string, pattern string, handler http.HandlerFunc) {
+	r.Handle(method, pattern, handler)
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	segments := splitPath(req.URL.Path)
+
+	var (
+		best       *route
+		bestParams map[string]string
+		bestStatic = -1
+		bestParam  = -1
+		allowed    = map[string]bool{}
+	)
+
+	for _, rt := range r.routes {
+		params, static, firstParam, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+
+		if !rt.allows(req.Method) {
+			allowed[rt.method] = true
+			if rt.method == http.MethodGet {
+				allowed[http.MethodHead] = true
+			}
+			continue
+		}
+
+		if static > bestStatic || static == bestStatic && firstParam > bestParam {
+			best, bestParams, bestStatic, bestParam = rt, params, static, firstParam
+		}
+	}
+
+	switch {
+	case best != nil:
+		if len(bestParams) > 0 {
+			req = req.WithContext(context.WithValue(req.Context(), ctxPathParamsKey{}, bestParams))
+		}
+		best.handler.ServeHTTP(w, req)
+	case len(allowed) > 0:
+		methods := make([]string, 0, len(allowed))
+		for m := range allowed {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	case r.NotFound != nil:
+		r.NotFound.ServeHTTP(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (rt *route) allows(method string) bool {
+	return rt.method == "" || rt.method == method || method == http.MethodHead && rt.method == http.MethodGet
+}
+
+// match returns the path parameters, the number of static segments matched
+// & the position of the first parameter (the segment count without parameters)
+func (rt *route) match(segments []string) (map[string]string, int, int, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, 0, 0, false
+	}
+
+	var params map[string]string
+	static := 0
+	firstParam := len(segments)
+	for i, s := range rt.segments {
+		if name, ok := paramName(s); ok {
+			if segments[i] == "" {
+				return nil, 0, 0, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+				firstParam = i
+			}
+			params[name] = segments[i]
+			continue
+		}
+
+		if s != segments[i] {
+			return nil, 0, 0, false
+		}
+		static++
+	}
+
+	return params, static, firstParam, true
+}
+
+// PathParam returns the value of the "{name}" segment of the matched route
+func PathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(ctxPathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
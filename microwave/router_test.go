@@ -0,0 +1,69 @@
+package microwave_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sqrt-7/microwave/microwave"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	test := assert.New(t)
+
+	router := microwave.NewRouter()
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name + ":" + microwave.PathParam(r, "id") + ":" + microwave.PathParam(r, "item")))
+		}
+	}
+	router.HandleFunc(http.MethodGet, "/users/{id}", handler("get"))
+	router.HandleFunc(http.MethodDelete, "/users/{id}", handler("delete"))
+	router.HandleFunc(http.MethodGet, "/users/me", handler("me"))
+	router.HandleFunc(http.MethodGet, "/users/{id}/items/{item}", handler("item"))
+	router.HandleFunc("", "/any", handler("any"))
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+		allow  string
+	}{
+		{method: http.MethodGet, path: "/users/42", status: http.StatusOK, body: "get:42:"},
+		{method: http.MethodDelete, path: "/users/42/", status: http.StatusOK, body: "delete:42:"},
+		{method: http.MethodGet, path: "/users/me", status: http.StatusOK, body: "me::"},
+		{method: http.MethodGet, path: "/users/42/items/7", status: http.StatusOK, body: "item:42:7"},
+		{method: http.MethodPost, path: "/any", status: http.StatusOK, body: "any::"},
+		{method: http.MethodHead, path: "/users/42", status: http.StatusOK},
+		{method: http.MethodPost, path: "/users/42", status: http.StatusMethodNotAllowed, allow: "DELETE, GET, HEAD"},
+		{method: http.MethodPost, path: "/users/me", status: http.StatusMethodNotAllowed, allow: "DELETE, GET, HEAD"},
+		{method: http.MethodPost, path: "/users/42/items/7", status: http.StatusMethodNotAllowed, allow: "GET, HEAD"},
+		{method: http.MethodGet, path: "/users", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/users//items/7", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+		test.Equal(tt.status, rec.Code, tt.method+" "+tt.path)
+		if tt.body != "" {
+			test.Equal(tt.body, rec.Body.String(), tt.method+" "+tt.path)
+		}
+		test.Equal(tt.allow, rec.Header().Get("Allow"), tt.method+" "+tt.path)
+	}
+
+	// with the same static segments the later parameter wins, whatever the registration order
+	for _, patterns := range [][]string{{"/a/{x}/c", "/a/b/{x}"}, {"/a/b/{x}", "/a/{x}/c"}} {
+		router := microwave.NewRouter()
+		for _, pattern := range patterns {
+			router.HandleFunc(http.MethodGet, pattern, handler(pattern))
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a/b/c", nil))
+		test.Equal("/a/b/{x}::", rec.Body.String())
+	}
+}
@@ -1,21 +1,56 @@
 package microwave
 
 import (
+	"net"
 	"net/http"
 
 	"google.golang.org/grpc"
 )
 
+// contextFieldHeaders maps incoming request headers (gRPC metadata keys are
+// lowercase) to the log fields they populate for the duration of the request
+var contextFieldHeaders = []struct {
+	header string
+	field  string
+}{
+	{header: "x-request-id", field: "RequestID"},
+	{header: "x-user-id", field: "UserID"},
+	{header: "x-tenant-id", field: "TenantID"},
+}
+
 type ServerWrapper interface {
 	Run(*Microwave)
 }
 
+// serverValidator is implemented by wrappers checked by Microwave.AddServer
+type serverValidator interface {
+	validate() error
+}
+
+// GRPCWrapper serves Server on Port (a port, host:port or unix:///path.sock) or on Listener
 type GRPCWrapper struct {
-	Port   string
-	Server *grpc.Server
+	Port     string
+	Listener net.Listener
+	Server   *grpc.Server
 }
 
+func (s GRPCWrapper) validate() error {
+	if s.Server == nil {
+		return ErrServerMissing
+	}
+	return validateListen(s.Port, s.Listener)
+}
+
+// HTTPWrapper serves a copy of Server on Port (a port, host:port or unix:///path.sock) or on Listener
 type HTTPWrapper struct {
-	Port   string
-	Server *http.Server
+	Port     string
+	Listener net.Listener
+	Server   *http.Server
+}
+
+func (s HTTPWrapper) validate() error {
+	if s.Server == nil {
+		return ErrServerMissing
+	}
+	return validateListen(s.Port, s.Listener)
 }
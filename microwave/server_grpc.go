@@ -4,39 +4,80 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"net"
 	"runtime/debug"
+	"sort"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
 	grpc_tags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	"github.com/sqrt-7/microwave/log"
-	"go.opencensus.io/plugin/ocgrpc"
+	"go.opencensus.io/trace/propagation"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
 )
 
-func NewGRPCServer(optUnaryInterceptors []grpc.UnaryServerInterceptor, optStreamInterceptors []grpc.StreamServerInterceptor) *grpc.Server {
-	return grpc.NewServer(
+// OpenCensus binary trace context metadata key
+const grpcTraceBinKey = "grpc-trace-bin"
+
+// NewGRPCServer chains the interceptors, extra options (e.g. TLSConfig.GRPCServerOption) are appended
+func NewGRPCServer(optUnaryInterceptors []grpc.UnaryServerInterceptor, optStreamInterceptors []grpc.StreamServerInterceptor, opts ...grpc.ServerOption) *grpc.Server {
+	return grpc.NewServer(append([]grpc.ServerOption{
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(optUnaryInterceptors...)),
 		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(optStreamInterceptors...)),
-		grpc.StatsHandler(&ocgrpc.ServerHandler{}), // this enables TraceID propagation
-	)
+		grpcStatsHandler(),
+	}, opts...)...)
+}
+
+// traceParentHandler makes the server span a child of the W3C traceparent
+// sent by callers without OpenCensus (e.g. OpenTelemetry clients)
+type traceParentHandler struct {
+	stats.Handler
+}
+
+func (h traceParentHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return h.Handler.TagRPC(grpcTraceParent(ctx), info)
+}
+
+// grpcTraceParent converts the traceparent metadata to grpc-trace-bin, which ocgrpc reads
+func grpcTraceParent(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(grpcTraceBinKey)) > 0 {
+		return ctx
+	}
+
+	v := md.Get(log.TraceParentHeader)
+	if len(v) == 0 {
+		return ctx
+	}
+
+	sc, ok := log.ParseTraceParent(v[0])
+	if !ok {
+		return ctx
+	}
+
+	md = md.Copy()
+	md.Set(grpcTraceBinKey, string(propagation.Binary(sc)))
+	return metadata.NewIncomingContext(ctx, md)
 }
 
 func DefaultGRPCInterceptors(logger log.Logger) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
 	unaryLogger := initUnaryLogger(logger)
 	streamLogger := initStreamLogger(logger)
 
+	// tags & context fields go first so the request loggers can see them
 	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		grpc_tags.UnaryServerInterceptor(),
+		initUnaryContext(logger),
 		unaryLogger,
 		grpc_recovery.UnaryServerInterceptor(grpc_recovery.WithRecoveryHandler(panicHandler(logger))),
-		grpc_tags.UnaryServerInterceptor(),
 	}
 
 	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_tags.StreamServerInterceptor(),
+		initStreamContext(logger),
 		streamLogger,
 		grpc_recovery.StreamServerInterceptor(grpc_recovery.WithRecoveryHandler(panicHandler(logger))),
-		grpc_tags.StreamServerInterceptor(),
 	}
 
 	return unaryInterceptors, streamInterceptors
@@ -45,30 +86,71 @@ func DefaultGRPCInterceptors(logger log.Logger) ([]grpc.UnaryServerInterceptor,
 func (s GRPCWrapper) Run(mw *Microwave) {
 	defer mw.wg.Done()
 
-	if string(s.Port[0]) != ":" {
-		s.Port = ":" + s.Port
-	}
-
-	lis, err := net.Listen("tcp", s.Port)
+	lis, addr, cleanup, err := mw.listeners.listen(s.Port, s.Listener)
 	if err != nil {
-		mw.logger.Error("GRPC_SERVER_ERROR").WithField("error", err.Error()).Send()
+		mw.logger.Error("GRPC_SERVER_ERROR").WithError(err).Send()
 		mw.errCh <- err
 		return
 	}
+	defer cleanup()
 
 	go func() {
 		if err := s.Server.Serve(lis); err != nil {
-			mw.logger.Error("GRPC_SERVER_ERROR").WithField("error", err.Error()).Send()
+			mw.logger.Error("GRPC_SERVER_ERROR").WithError(err).Send()
 			mw.errCh <- err
 		}
 	}()
 
-	mw.logger.Info("GRPC_SERVER_STARTED").WithField("port", s.Port).Send()
+	mw.logger.Info("GRPC_SERVER_STARTED").WithField("port", addr).Send()
 
 	<-mw.shutdownCh
 
 	s.Server.GracefulStop()
-	mw.logger.Info("GRPC_SERVER_STOPPED").WithField("port", s.Port).Send()
+	mw.logger.Info("GRPC_SERVER_STOPPED").WithField("port", addr).Send()
+}
+
+// initUnaryContext attaches the logger & request log fields to the context
+func initUnaryContext(logger log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(grpcLogContext(ctx, logger), req)
+	}
+}
+
+// initStreamContext attaches the logger & request log fields to the stream context
+func initStreamContext(logger log.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = grpcLogContext(ss.Context(), logger)
+
+		return handler(srv, wrapped)
+	}
+}
+
+// grpcLogContext collects log fields from the incoming metadata & grpc_tags. Tags are copied once,
+// those set later by interceptors or handlers aren't added to the log fields
+func grpcLogContext(ctx context.Context, logger log.Logger) context.Context {
+	var fields []log.Field
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, h := range contextFieldHeaders {
+			if v := md.Get(h.header); len(v) > 0 && v[0] != "" {
+				fields = append(fields, log.F(h.field, v[0]))
+			}
+		}
+	}
+
+	tags := grpc_tags.Extract(ctx).Values()
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, log.F(k, tags[k]))
+	}
+
+	ctx = log.ContextWithFields(ctx, fields...)
+	return log.ContextWithLogger(ctx, logger)
 }
 
 // initUnaryLogger creates a logger for unary requests
@@ -1,13 +1,465 @@
 package microwave
 
-import "net/http"
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
 
-func NewHTTPServer() *http.Server {
-	// TODO
-	return &http.Server{}
+	"github.com/sqrt-7/microwave/log"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/plugin/ochttp/propagation/tracecontext"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+const (
+	headerRequestID = "X-Request-Id"
+
+	httpShutdownTimeout = 30 * time.Second
+
+	// time given to accepted connections to send their first request before Shutdown
+	httpDrainTimeout = 5 * time.Second
+)
+
+var (
+	// MeasureHTTPPanics counts the panics recovered by HTTPRecovery
+	MeasureHTTPPanics = stats.Int64("microwave/http/server/panics", "Number of panics recovered in HTTP handlers", stats.UnitDimensionless)
+
+	// HTTPPanicsView counts recovered panics by method, register it with view.Register to export it
+	HTTPPanicsView = &view.View{
+		Name:        "microwave/http/server/panics",
+		Description: "Count of panics recovered in HTTP handlers, by HTTP method",
+		Measure:     MeasureHTTPPanics,
+		TagKeys:     []tag.Key{ochttp.Method},
+		Aggregation: view.Count(),
+	}
+)
+
+// HTTPMiddleware wraps an http.Handler, the first middleware in a chain is the outermost
+type HTTPMiddleware func(http.Handler) http.Handler
+
+func NewHTTPServer(handler http.Handler, middlewares []HTTPMiddleware) *http.Server {
+	if handler == nil {
+		handler = http.NotFoundHandler()
+	}
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return &http.Server{Handler: handler}
+}
+
+// cloneHTTPServer copies the configuration of server, the copy is served instead of the caller's server
+func cloneHTTPServer(server *http.Server) *http.Server {
+	return &http.Server{
+		Addr:              server.Addr,
+		Handler:           server.Handler,
+		TLSConfig:         server.TLSConfig,
+		ReadTimeout:       server.ReadTimeout,
+		ReadHeaderTimeout: server.ReadHeaderTimeout,
+		WriteTimeout:      server.WriteTimeout,
+		IdleTimeout:       server.IdleTimeout,
+		MaxHeaderBytes:    server.MaxHeaderBytes,
+		TLSNextProto:      server.TLSNextProto,
+		ConnState:         server.ConnState,
+		ErrorLog:          server.ErrorLog,
+		BaseContext:       server.BaseContext,
+		ConnContext:       server.ConnContext,
+	}
+}
+
+func DefaultHTTPMiddlewares(logger log.Logger) []HTTPMiddleware {
+	// request ID & span go first so the log context & request loggers can see them
+	return []HTTPMiddleware{
+		HTTPRequestID(),
+		HTTPTrace(),
+		HTTPLogContext(logger),
+		HTTPPeerIdentity(),
+		httpLogger(logger),
+		HTTPRecovery(logger),
+	}
 }
 
 func (s HTTPWrapper) Run(mw *Microwave) {
-	mw.logger.Info("http server running").WithField("port", s.Port).Send()
-	// TODO
+	defer mw.wg.Done()
+
+	lis, addr, cleanup, err := mw.listeners.listen(s.Port, s.Listener)
+	if err != nil {
+		mw.logger.Error("HTTP_SERVER_ERROR").WithError(err).Send()
+		mw.errCh <- err
+		return
+	}
+	defer cleanup()
+
+	// served on a copy so runs don't stack ConnState hooks
+	server := cloneHTTPServer(s.Server)
+	drain := newHTTPDrain(lis, server)
+	lis = drain
+
+	// TLS is served when the server has a TLSConfig (e.g. from TLSConfig.Config)
+	useTLS := server.TLSConfig != nil
+
+	go func() {
+		serve := server.Serve
+		if useTLS {
+			serve = func(lis net.Listener) error { return server.ServeTLS(lis, "", "") }
+		}
+
+		if err := serve(lis); err != nil && err != http.ErrServerClosed {
+			mw.logger.Error("HTTP_SERVER_ERROR").WithError(err).Send()
+			mw.errCh <- err
+		}
+	}()
+
+	mw.logger.Info("HTTP_SERVER_STARTED").WithField("port", addr).WithField("tls", useTLS).Send()
+
+	<-mw.shutdownCh
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	drain.drain(ctx, mw.listeners.isHandedOver())
+
+	if err := server.Shutdown(ctx); err != nil {
+		mw.logger.Error("HTTP_SERVER_ERROR").WithError(err).Send()
+	}
+	mw.logger.Info("HTTP_SERVER_STOPPED").WithField("port", addr).Send()
+}
+
+// httpDrain stops accepting & lets the accepted connections send their first request before
+// http.Server.Shutdown, which closes the connections whose first request is read after it
+// started (e.g. connections accepted from a listener handed over to a restarted process)
+type httpDrain struct {
+	net.Listener
+
+	mu       sync.Mutex
+	newConns map[net.Conn]struct{}
+	changed  chan struct{} // closed when newConns changes, while drain waits
+
+	draining  chan struct{}
+	parked    chan struct{} // closed once the server waits in Accept for the listener to close
+	parkOnce  sync.Once
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newHTTPDrain tracks the connections of server (chaining its ConnState hook)
+func newHTTPDrain(lis net.Listener, server *http.Server) *httpDrain {
+	d := &httpDrain{
+		Listener: lis,
+		newConns: make(map[net.Conn]struct{}),
+		draining: make(chan struct{}),
+		parked:   make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+
+	connState := server.ConnState
+	server.ConnState = func(conn net.Conn, state http.ConnState) {
+		d.mu.Lock()
+		if state == http.StateNew {
+			d.newConns[conn] = struct{}{}
+		} else {
+			delete(d.newConns, conn)
+		}
+		if d.changed != nil {
+			close(d.changed)
+			d.changed = nil
+		}
+		d.mu.Unlock()
+
+		if connState != nil {
+			connState(conn, state)
+		}
+	}
+
+	return d
+}
+
+func (d *httpDrain) Accept() (net.Conn, error) {
+	if d.isDraining() {
+		return nil, d.park()
+	}
+
+	conn, err := d.Listener.Accept()
+	if err != nil && d.isDraining() {
+		// the deadline set by drain
+		return nil, d.park()
+	}
+	return conn, err
+}
+
+func (d *httpDrain) isDraining() bool {
+	select {
+	case <-d.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// park leaves the pending connections to the other processes sharing the listener
+func (d *httpDrain) park() error {
+	d.parkOnce.Do(func() { close(d.parked) })
+	<-d.closed
+	return net.ErrClosed
+}
+
+func (d *httpDrain) Close() error {
+	d.closeOnce.Do(func() { close(d.closed) })
+	return d.Listener.Close()
+}
+
+// drain stops accepting & waits for the new connections to read their first request (the server
+// marks them active before the ConnState hook), when the listener is handed over to a restarted
+// process. Otherwise Shutdown closes it right away
+func (d *httpDrain) drain(ctx context.Context, handedOver bool) {
+	// interrupts a blocked Accept without closing the listener
+	l, ok := d.Listener.(interface{ SetDeadline(time.Time) error })
+	if !handedOver || !ok {
+		return
+	}
+
+	close(d.draining)
+	_ = l.SetDeadline(time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, httpDrainTimeout)
+	defer cancel()
+
+	// a connection returned by the last Accept is tracked before the server calls Accept again
+	select {
+	case <-d.parked:
+	case <-ctx.Done():
+		return
+	}
+
+	for {
+		d.mu.Lock()
+		if len(d.newConns) == 0 {
+			d.mu.Unlock()
+			return
+		}
+		changed := make(chan struct{})
+		d.changed = changed
+		d.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// HTTPRequestID sets a generated X-Request-Id on requests without one,
+// the ID is echoed in the response
+func HTTPRequestID() HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(headerRequestID)
+			if id == "" {
+				id = newRequestID()
+				r.Header.Set(headerRequestID, id)
+			}
+			w.Header().Set(headerRequestID, id)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HTTPTrace starts a server span, continuing the caller's W3C traceparent
+func HTTPTrace() HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return &ochttp.Handler{
+			Handler:     next,
+			Propagation: &tracecontext.HTTPFormat{},
+		}
+	}
+}
+
+// HTTPLogContext attaches the logger & request log fields (from headers) to the request context,
+// entries are correlated with the caller's W3C traceparent when the request has no span
+func HTTPLogContext(logger log.Logger) HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var fields []log.Field
+			for _, h := range contextFieldHeaders {
+				if v := r.Header.Get(h.header); v != "" {
+					fields = append(fields, log.F(h.field, v))
+				}
+			}
+
+			ctx := log.ContextWithFields(r.Context(), fields...)
+			ctx = log.ContextWithLogger(ctx, logger)
+
+			if trace.FromContext(ctx) == nil {
+				if sc, ok := log.ParseTraceParent(r.Header.Get(log.TraceParentHeader)); ok {
+					ctx = log.ContextWithSpanContext(ctx, sc)
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// httpLogger logs requests & responses
+func httpLogger(logger log.Logger) HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			logger.Info("HTTP_IN").For(ctx).
+				WithField("method", r.Method).
+				WithField("path", r.URL.Path).
+				Send()
+
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			logger.Info("HTTP_OUT").For(ctx).
+				WithField("method", r.Method).
+				WithField("path", r.URL.Path).
+				WithField("status", rec.Status()).
+				WithField("duration", time.Since(start)).
+				Send()
+		})
+	}
+}
+
+// HTTPRecovery logs the panic & stack trace like panicHandler & responds with a JSON 500
+// (without the panic value). http.ErrAbortHandler is re-panicked for net/http to abort the
+// response silently, panics after the response has started also abort it
+func HTTPRecovery(logger log.Logger) HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w}
+
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				logger.Error("HTTP_PANIC").For(r.Context()).
+					WithField("panic", fmt.Sprint(p)).
+					WithField("stack", base64.RawStdEncoding.EncodeToString(debug.Stack())).
+					WithField("method", r.Method).
+					WithField("path", r.URL.Path).
+					Send()
+
+				_ = stats.RecordWithTags(r.Context(),
+					[]tag.Mutator{tag.Upsert(ochttp.Method, r.Method)},
+					MeasureHTTPPanics.M(1),
+				)
+
+				if rec.status != 0 {
+					// part of the response is sent, a 500 can't be written anymore
+					panic(http.ErrAbortHandler)
+				}
+
+				writeHTTPError(w, http.StatusInternalServerError)
+			}()
+
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// httpError is the JSON body of error responses
+type httpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeHTTPError responds with the status & its generic text (no internal details)
+func writeHTTPError(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(httpError{Code: status, Message: http.StatusText(status)})
+}
+
+// statusRecorder keeps the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Status returns the written status code (200 if the handler wrote nothing)
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+func (s *statusRecorder) Flush() {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes the connection to the handler (e.g. WebSocket upgrades), the status is 101
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := h.Hijack()
+	if err == nil && s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (s *statusRecorder) Push(target string, opts *http.PushOptions) error {
+	if p, ok := s.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,306 @@
+package microwave
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/http2/hpack"
+	"google.golang.org/grpc"
+)
+
+const (
+	// time given to a new connection to send the bytes identifying its protocol
+	muxSniffTimeout = 10 * time.Second
+
+	// frames read looking for the first HEADERS frame of an HTTP/2 connection
+	muxMaxSniffFrames = 10
+)
+
+// MuxWrapper serves the gRPC & HTTP servers on a single port. Connections are sniffed:
+// HTTP/2 connections opening with an application/grpc request go to the gRPC server,
+// the rest (HTTP/1.1 & h2c) to the HTTP server. Both shut down gracefully on Microwave.Stop.
+// Port is a port, host:port or unix:///path.sock, or Listener is used instead
+type MuxWrapper struct {
+	Port       string
+	Listener   net.Listener
+	GRPCServer *grpc.Server
+	HTTPServer *http.Server
+}
+
+func (s MuxWrapper) validate() error {
+	if s.GRPCServer == nil || s.HTTPServer == nil {
+		return ErrServerMissing
+	}
+	return validateListen(s.Port, s.Listener)
+}
+
+func (s MuxWrapper) Run(mw *Microwave) {
+	defer mw.wg.Done()
+
+	lis, addr, cleanup, err := mw.listeners.listen(s.Port, s.Listener)
+	if err != nil {
+		mw.logger.Error("MUX_SERVER_ERROR").WithError(err).Send()
+		mw.errCh <- err
+		return
+	}
+	defer cleanup()
+
+	grpcLis := newMuxListener(lis.Addr())
+	httpLis := newMuxListener(lis.Addr())
+
+	// plain HTTP/2 (prior knowledge & h2c upgrades) for the HTTP server, on a copy so runs don't stack
+	httpServer := cloneHTTPServer(s.HTTPServer)
+	httpServer.Handler = h2c.NewHandler(s.HTTPServer.Handler, &http2.Server{})
+
+	go func() {
+		if err := s.GRPCServer.Serve(grpcLis); err != nil {
+			mw.logger.Error("MUX_SERVER_ERROR").WithError(err).Send()
+			mw.errCh <- err
+		}
+	}()
+
+	go func() {
+		if err := httpServer.Serve(httpLis); err != nil && err != http.ErrServerClosed {
+			mw.logger.Error("MUX_SERVER_ERROR").WithError(err).Send()
+			mw.errCh <- err
+		}
+	}()
+
+	go serveMux(lis, grpcLis, httpLis)
+
+	mw.logger.Info("MUX_SERVER_STARTED").WithField("port", addr).Send()
+
+	<-mw.shutdownCh
+
+	// stop accepting, then let both servers finish their requests
+	_ = lis.Close()
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.GRPCServer.GracefulStop()
+	}()
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			mw.logger.Error("MUX_SERVER_ERROR").WithError(err).Send()
+		}
+	}()
+	wg.Wait()
+
+	mw.logger.Info("MUX_SERVER_STOPPED").WithField("port", addr).Send()
+}
+
+// serveMux dispatches the accepted connections until lis is closed,
+// the servers close their own listener when they stop
+func serveMux(lis net.Listener, grpcLis *muxListener, httpLis *muxListener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if isTemporaryAcceptError(err) {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return
+		}
+
+		go func() {
+			sniffed, isGRPC, err := sniffConn(conn)
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+
+			target := httpLis
+			if isGRPC {
+				target = grpcLis
+			}
+			if !target.push(sniffed) {
+				_ = conn.Close()
+			}
+		}()
+	}
+}
+
+// isTemporaryAcceptError matches the accept errors worth a retry (e.g. too many open files)
+func isTemporaryAcceptError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED, syscall.ECONNRESET} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffConn reports whether conn is a gRPC connection, the returned conn replays the sniffed bytes
+func sniffConn(conn net.Conn) (net.Conn, bool, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(muxSniffTimeout))
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	buf := &bytes.Buffer{}
+	r := &countingReader{r: bufio.NewReader(io.TeeReader(conn, buf))}
+
+	// compared byte by byte, HTTP/1.x requests can be shorter than the preface
+	isHTTP2 := true
+	c := make([]byte, 1)
+	for i := 0; i < len(http2.ClientPreface); i++ {
+		if _, err := io.ReadFull(r, c); err != nil {
+			return nil, false, err
+		}
+		if c[0] != http2.ClientPreface[i] {
+			isHTTP2 = false
+			break
+		}
+	}
+
+	isGRPC := false
+	var drop [2]int
+	if isHTTP2 {
+		isGRPC, drop = sniffGRPCHeaders(conn, r)
+	}
+
+	// buf holds every byte read, including the ones read ahead by bufio after the dropped frame
+	b := buf.Bytes()
+	replay := append(b[:drop[0]:drop[0]], b[drop[1]:]...)
+
+	return &muxConn{Conn: conn, r: io.MultiReader(bytes.NewReader(replay), conn)}, isGRPC, nil
+}
+
+// sniffGRPCHeaders reads frames up to the first HEADERS frame & checks its content-type.
+// Some clients (e.g. grpc-java) wait for the server SETTINGS before sending HEADERS, so they're
+// sent first. The client's ACK is read too & returned as the byte range to drop, the real server
+// rejects an ACK for SETTINGS it didn't send
+func sniffGRPCHeaders(conn net.Conn, r *countingReader) (bool, [2]int) {
+	framer := http2.NewFramer(conn, r)
+	if err := framer.WriteSettings(); err != nil {
+		return false, [2]int{}
+	}
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+
+	var (
+		drop    [2]int
+		acked   bool
+		headers bool
+		isGRPC  bool
+	)
+	for i := 0; i < muxMaxSniffFrames && !(acked && headers); i++ {
+		start := r.n
+		f, err := framer.ReadFrame()
+		if err != nil {
+			break
+		}
+
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if f.IsAck() && !acked {
+				acked = true
+				drop = [2]int{start, r.n}
+			}
+		case *http2.MetaHeadersFrame:
+			if !headers {
+				headers = true
+				for _, hf := range f.RegularFields() {
+					if hf.Name == "content-type" {
+						isGRPC = isGRPCContentType(hf.Value)
+					}
+				}
+			}
+		}
+	}
+
+	return isGRPC, drop
+}
+
+// countingReader counts the bytes read
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += n
+	return n, err
+}
+
+// isGRPCContentType matches application/grpc & application/grpc+proto..., not application/grpc-web
+func isGRPCContentType(contentType string) bool {
+	if !strings.HasPrefix(contentType, "application/grpc") {
+		return false
+	}
+
+	rest := contentType[len("application/grpc"):]
+	return rest == "" || rest[0] == '+' || rest[0] == ';'
+}
+
+// muxConn replays the sniffed bytes before reading from the connection
+type muxConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *muxConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// muxListener is a net.Listener returning the connections dispatched by serveMux
+type muxListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMuxListener(addr net.Addr) *muxListener {
+	return &muxListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *muxListener) push(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.addr
+}
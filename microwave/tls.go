@@ -0,0 +1,332 @@
+package microwave
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sqrt-7/microwave/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	// how often handshakes check the certificate files for changes
+	defaultTLSReloadInterval = 10 * time.Second
+
+	spiffeScheme = "spiffe"
+)
+
+var (
+	ErrTLSLoggerMissing  = errors.New("tls logger missing")
+	ErrTLSCertMissing    = errors.New("tls certificate or key file missing")
+	ErrTLSClientCAEmpty  = errors.New("tls client CA file has no certificates")
+	ErrTLSVersionInvalid = errors.New("tls version invalid")
+	ErrTLSCipherInvalid  = errors.New("tls cipher suite invalid")
+)
+
+// TLSOption configures NewTLSConfig
+type TLSOption func(*TLSConfig) error
+
+// TLSClientCA requires clients to present a certificate signed by a CA in caFile (mutual TLS)
+func TLSClientCA(caFile string) TLSOption {
+	return func(c *TLSConfig) error {
+		c.clientCAFile = caFile
+		return nil
+	}
+}
+
+// TLSMinVersion sets the minimum TLS version (tls.VersionTLS12 by default)
+func TLSMinVersion(version uint16) TLSOption {
+	return func(c *TLSConfig) error {
+		if version < tls.VersionTLS10 || version > tls.VersionTLS13 {
+			return errors.Wrapf(ErrTLSVersionInvalid, "%#04x", version)
+		}
+		c.minVersion = version
+		return nil
+	}
+}
+
+// TLSCipherSuites restricts the TLS 1.0-1.2 cipher suites, only the suites in tls.CipherSuites are accepted
+// & TLS 1.3 suites are rejected (they're not configurable)
+func TLSCipherSuites(ids ...uint16) TLSOption {
+	return func(c *TLSConfig) error {
+		secure := make(map[uint16]bool)
+		for _, s := range tls.CipherSuites() {
+			for _, v := range s.SupportedVersions {
+				if v < tls.VersionTLS13 {
+					secure[s.ID] = true
+				}
+			}
+		}
+
+		for _, id := range ids {
+			if !secure[id] {
+				return errors.Wrap(ErrTLSCipherInvalid, tls.CipherSuiteName(id))
+			}
+		}
+		c.cipherSuites = ids
+		return nil
+	}
+}
+
+// TLSReloadInterval sets how often handshakes check the files for changes (0 checks on every handshake)
+func TLSReloadInterval(interval time.Duration) TLSOption {
+	return func(c *TLSConfig) error {
+		c.reloadInterval = interval
+		return nil
+	}
+}
+
+// TLSConfig serves a certificate (& verifies client certificates) loaded from files,
+// which are reloaded when they change on disk. Use Config for an http.Server
+// (HTTPWrapper serves TLS when Server.TLSConfig is set) & GRPCServerOption for NewGRPCServer
+type TLSConfig struct {
+	logger         log.Logger
+	certFile       string
+	keyFile        string
+	clientCAFile   string
+	minVersion     uint16
+	cipherSuites   []uint16
+	reloadInterval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	loaded    string // mod times & sizes of the loaded files
+	lastCheck time.Time
+	checking  bool // a handshake is checking the files, the others keep the loaded certificates
+}
+
+// NewTLSConfig loads the files, reload errors are logged & the previous certificates kept
+func NewTLSConfig(logger log.Logger, certFile string, keyFile string, options ...TLSOption) (*TLSConfig, error) {
+	if logger == nil {
+		return nil, ErrTLSLoggerMissing
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, ErrTLSCertMissing
+	}
+
+	c := &TLSConfig{
+		logger:         logger,
+		certFile:       certFile,
+		keyFile:        keyFile,
+		minVersion:     tls.VersionTLS12,
+		reloadInterval: defaultTLSReloadInterval,
+	}
+
+	for _, opt := range options {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	version, err := c.filesVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, clientCAs, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	c.cert, c.clientCAs, c.loaded, c.lastCheck = cert, clientCAs, version, time.Now()
+
+	return c, nil
+}
+
+// Config returns the server config for an http.Server (HTTP/2 & HTTP/1.1)
+func (c *TLSConfig) Config() *tls.Config {
+	return c.config([]string{"h2", "http/1.1"})
+}
+
+// GRPCServerOption returns the transport credentials for NewGRPCServer
+func (c *TLSConfig) GRPCServerOption() grpc.ServerOption {
+	return grpc.Creds(credentials.NewTLS(c.config([]string{"h2"})))
+}
+
+// config resolves the current certificates on each handshake. GetCertificate is also set for
+// http.Server.ServeTLS, which only accepts GetConfigForClient as a certificate source from Go 1.22
+func (c *TLSConfig) config(nextProtos []string) *tls.Config {
+	return &tls.Config{
+		MinVersion: c.minVersion,
+		NextProtos: nextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := c.current()
+			return cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCAs := c.current()
+
+			cfg := &tls.Config{
+				Certificates: []tls.Certificate{*cert},
+				MinVersion:   c.minVersion,
+				CipherSuites: c.cipherSuites,
+				NextProtos:   nextProtos,
+			}
+			if clientCAs != nil {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = clientCAs
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// current reloads the files if they changed since the last check. The files are read outside
+// the lock by a single handshake, the other handshakes get the loaded certificates meanwhile
+func (c *TLSConfig) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.Lock()
+	check := !c.checking && time.Since(c.lastCheck) >= c.reloadInterval
+	if check {
+		c.checking = true
+	}
+	loaded := c.loaded
+	c.mu.Unlock()
+
+	if check {
+		c.reload(loaded)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, c.clientCAs
+}
+
+// reload loads the files if their version changed from loaded
+func (c *TLSConfig) reload(loaded string) {
+	defer func() {
+		c.mu.Lock()
+		c.lastCheck = time.Now()
+		c.checking = false
+		c.mu.Unlock()
+	}()
+
+	version, err := c.filesVersion()
+	if err == nil && version != loaded {
+		// a failed load (e.g. key not written yet) is retried on the next check
+		var cert *tls.Certificate
+		var clientCAs *x509.CertPool
+		if cert, clientCAs, err = c.load(); err == nil {
+			c.mu.Lock()
+			c.cert, c.clientCAs, c.loaded = cert, clientCAs, version
+			c.mu.Unlock()
+
+			c.logger.Info("TLS_CERT_RELOADED").WithField("cert", c.certFile).Send()
+		}
+	}
+	if err != nil {
+		c.logger.Error("TLS_CERT_RELOAD_ERROR").WithError(err).WithField("cert", c.certFile).Send()
+	}
+}
+
+func (c *TLSConfig) load() (*tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "load tls key pair")
+	}
+
+	var clientCAs *x509.CertPool
+	if c.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.clientCAFile)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "read tls client CA")
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, nil, ErrTLSClientCAEmpty
+		}
+	}
+
+	return &cert, clientCAs, nil
+}
+
+// filesVersion identifies the current content of the files
+func (c *TLSConfig) filesVersion() (string, error) {
+	var sb strings.Builder
+	for _, file := range []string{c.certFile, c.keyFile, c.clientCAFile} {
+		if file == "" {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", errors.Wrap(err, "stat tls file")
+		}
+		fmt.Fprintf(&sb, "%d:%d;", info.ModTime().UnixNano(), info.Size())
+	}
+
+	return sb.String(), nil
+}
+
+// PeerIdentity is the identity of a client verified by mutual TLS
+type PeerIdentity struct {
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+	SPIFFEID   string // the spiffe:// URI SAN, if any
+}
+
+type peerIdentityCtxKey struct{}
+
+// PeerIdentityFromContext returns the verified client identity of a gRPC call or
+// an HTTP request (see HTTPPeerIdentity), false without a verified client certificate
+func PeerIdentityFromContext(ctx context.Context) (PeerIdentity, bool) {
+	if id, ok := ctx.Value(peerIdentityCtxKey{}).(PeerIdentity); ok {
+		return id, true
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			return peerIdentity(info.State)
+		}
+	}
+
+	return PeerIdentity{}, false
+}
+
+// HTTPPeerIdentity makes the verified client identity available through PeerIdentityFromContext
+func HTTPPeerIdentity() HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				if id, ok := peerIdentity(*r.TLS); ok {
+					r = r.WithContext(context.WithValue(r.Context(), peerIdentityCtxKey{}, id))
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func peerIdentity(state tls.ConnectionState) (PeerIdentity, bool) {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return PeerIdentity{}, false
+	}
+
+	cert := state.VerifiedChains[0][0]
+	id := PeerIdentity{
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+	}
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+		if uri.Scheme == spiffeScheme && id.SPIFFEID == "" {
+			id.SPIFFEID = uri.String()
+		}
+	}
+
+	return id, true
+}
@@ -0,0 +1,346 @@
+package microwave_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sqrt-7/microwave/log"
+	"github.com/sqrt-7/microwave/log/logtest"
+	"github.com/sqrt-7/microwave/microwave"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate & key for cn, with the localhost & uri SANs
+func (ca *testCA) issue(t *testing.T, cn string, uri string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if uri != "" {
+		u, err := url.Parse(uri)
+		assert.Nil(t, err)
+		tmpl.URIs = []*url.URL{u}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) clientConfig(t *testing.T, cn string, uri string) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+
+	cfg := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	if cn != "" {
+		certPEM, keyPEM := ca.issue(t, cn, uri)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		assert.Nil(t, err)
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	assert.Nil(t, ioutil.WriteFile(path, data, 0600))
+}
+
+func TestTLSConfig(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+	dir, err := ioutil.TempDir("", "microwave-tls")
+	if !test.Nil(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	certPEM, keyPEM := ca.issue(t, "server-1", "")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, caFile, ca.pem)
+
+	// validation
+	_, err = microwave.NewTLSConfig(nil, certFile, keyFile)
+	test.Equal(microwave.ErrTLSLoggerMissing, err)
+	_, err = microwave.NewTLSConfig(rec, "", keyFile)
+	test.Equal(microwave.ErrTLSCertMissing, err)
+	_, err = microwave.NewTLSConfig(rec, certFile, filepath.Join(dir, "missing.key"))
+	test.NotNil(err)
+	_, err = microwave.NewTLSConfig(rec, certFile, keyFile, microwave.TLSMinVersion(0x0200))
+	test.Equal(microwave.ErrTLSVersionInvalid, errors.Cause(err))
+	_, err = microwave.NewTLSConfig(rec, certFile, keyFile, microwave.TLSCipherSuites(tls.TLS_RSA_WITH_RC4_128_SHA))
+	test.Equal(microwave.ErrTLSCipherInvalid, errors.Cause(err))
+	_, err = microwave.NewTLSConfig(rec, certFile, keyFile, microwave.TLSCipherSuites(tls.TLS_AES_128_GCM_SHA256))
+	test.Equal(microwave.ErrTLSCipherInvalid, errors.Cause(err))
+	_, err = microwave.NewTLSConfig(rec, certFile, keyFile, microwave.TLSClientCA(keyFile))
+	test.Equal(microwave.ErrTLSClientCAEmpty, err)
+
+	cfg, err := microwave.NewTLSConfig(rec, certFile, keyFile,
+		microwave.TLSClientCA(caFile),
+		microwave.TLSMinVersion(tls.VersionTLS12),
+		microwave.TLSCipherSuites(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256),
+		microwave.TLSReloadInterval(0),
+	)
+	if !test.Nil(err) {
+		return
+	}
+
+	// a certificate source for http.Server.ServeTLS before Go 1.22
+	serverCert, err := cfg.Config().GetCertificate(&tls.ClientHelloInfo{})
+	if test.Nil(err) && test.NotNil(serverCert) {
+		leaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+		if test.Nil(err) {
+			test.Equal("server-1", leaf.Subject.CommonName)
+		}
+	}
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", cfg.Config())
+	if !test.Nil(err) {
+		return
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*tls.Conn).Handshake()
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	handshake := func(cfg *tls.Config) (*x509.Certificate, error) {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), cfg)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		// TLS 1.3 client certificate errors are reported on the first read
+		if _, err := conn.Read(make([]byte, 1)); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return conn.ConnectionState().PeerCertificates[0], nil
+	}
+
+	cert, err := handshake(ca.clientConfig(t, "client", ""))
+	if test.Nil(err) {
+		test.Equal("server-1", cert.Subject.CommonName)
+	}
+
+	// mutual TLS, minimum version
+	_, err = handshake(ca.clientConfig(t, "", ""))
+	test.NotNil(err)
+	old := ca.clientConfig(t, "client", "")
+	old.MaxVersion = tls.VersionTLS11
+	_, err = handshake(old)
+	test.NotNil(err)
+
+	// reloaded when the files change, broken files keep the previous certificate
+	writeFile(t, certFile, []byte("broken"))
+	cert, err = handshake(ca.clientConfig(t, "client", ""))
+	if test.Nil(err) {
+		test.Equal("server-1", cert.Subject.CommonName)
+	}
+	rec.HasEntry(t, log.LevelError, "TLS_CERT_RELOAD_ERROR", log.F("cert", certFile))
+
+	certPEM, keyPEM = ca.issue(t, "server-2", "")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	cert, err = handshake(ca.clientConfig(t, "client", ""))
+	if test.Nil(err) {
+		test.Equal("server-2", cert.Subject.CommonName)
+	}
+	rec.HasEntry(t, log.LevelInfo, "TLS_CERT_RELOADED", log.F("cert", certFile))
+
+	// concurrent handshakes while the files change
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				cfg, err := cfg.Config().GetConfigForClient(&tls.ClientHelloInfo{})
+				if test.Nil(err) {
+					test.Len(cfg.Certificates, 1)
+				}
+			}
+		}()
+	}
+	certPEM, keyPEM = ca.issue(t, "server-3", "")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	wg.Wait()
+}
+
+func TestPeerIdentity(t *testing.T) {
+	test := assert.New(t)
+
+	rec := logtest.New("my-service")
+	dir, err := ioutil.TempDir("", "microwave-tls")
+	if !test.Nil(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	certPEM, keyPEM := ca.issue(t, "server", "")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, caFile, ca.pem)
+
+	cfg, err := microwave.NewTLSConfig(rec, certFile, keyFile, microwave.TLSClientCA(caFile))
+	if !test.Nil(err) {
+		return
+	}
+
+	const spiffeID = "spiffe://example.org/ns/default/sa/client"
+	expected := microwave.PeerIdentity{
+		CommonName: "client",
+		DNSNames:   []string{"localhost"},
+		URIs:       []string{spiffeID},
+		SPIFFEID:   spiffeID,
+	}
+
+	// gRPC
+	identities := make(chan microwave.PeerIdentity, 1)
+	unary, stream := microwave.DefaultGRPCInterceptors(rec)
+	unary = append(unary, func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id, _ := microwave.PeerIdentityFromContext(ctx)
+		identities <- id
+		return handler(ctx, req)
+	})
+	grpcSrv := microwave.NewGRPCServer(unary, stream, cfg.GRPCServerOption())
+	grpc_health_v1.RegisterHealthServer(grpcSrv, health.NewServer())
+
+	grpcLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !test.Nil(err) {
+		return
+	}
+	go func() { _ = grpcSrv.Serve(grpcLis) }()
+	defer grpcSrv.Stop()
+
+	conn, err := grpc.Dial(grpcLis.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(ca.clientConfig(t, "client", spiffeID))))
+	if !test.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if test.Nil(err) {
+		test.Equal(expected, <-identities)
+	}
+
+	// HTTP through HTTPWrapper
+	mw, err := microwave.New("my-service", microwave.CustomLogger(rec))
+	if !test.Nil(err) {
+		return
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !test.Nil(err) {
+		return
+	}
+	port := fmt.Sprint(lis.Addr().(*net.TCPAddr).Port)
+	_ = lis.Close()
+
+	router := microwave.NewRouter()
+	router.HandleFunc(http.MethodGet, "/whoami", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := microwave.PeerIdentityFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.Proto, id.CommonName, id.SPIFFEID)
+	})
+	httpSrv := microwave.NewHTTPServer(router, microwave.DefaultHTTPMiddlewares(rec))
+	httpSrv.TLSConfig = cfg.Config()
+
+	mw.WaitGroup().Add(1)
+	go microwave.HTTPWrapper{Port: port, Server: httpSrv}.Run(mw)
+	defer mw.Stop()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: ca.clientConfig(t, "client", spiffeID), ForceAttemptHTTP2: true}}
+	var resp *http.Response
+	test.Eventually(func() bool {
+		resp, err = client.Get("https://127.0.0.1:" + port + "/whoami")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	if !test.Nil(err) {
+		return
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	test.Equal("HTTP/2.0 client "+spiffeID, string(body))
+
+	rec.HasEntry(t, log.LevelInfo, "HTTP_SERVER_STARTED", log.F("port", ":"+port), log.F("tls", true))
+}